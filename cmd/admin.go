@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ongy/k8s-auto-arch/internal/admin"
+)
+
+var (
+	adminURL    = "http://localhost:8081"
+	purgePrefix = ""
+)
+
+func adminClient() (*admin.Client, error) {
+	if adminTokenFile == "" {
+		return nil, fmt.Errorf("--admin-token-file is required")
+	}
+
+	token, err := readSecret(adminTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("--admin-token-file: %w", err)
+	}
+
+	return &admin.Client{URL: adminURL, Token: token}, nil
+}
+
+func platforms(entry admin.Entry) string {
+	if entry.Platforms == nil {
+		return "*"
+	}
+
+	return strings.Join(entry.Platforms, ",")
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and manage the image cache of a running webhook",
+	Long: `Talks to the admin API of a running webhook, which is enabled with --admin-address.
+
+	The API is usually reached through kubectl port-forward.`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached images",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+
+		entries, err := client.List(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("list images: %w", err)
+		}
+
+		writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "REFERENCE\tDIGEST\tPLATFORMS\tSOURCE\tAGE")
+		for _, entry := range entries {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", entry.Reference, entry.Digest, platforms(entry), entry.Source, entry.Age)
+		}
+
+		return writer.Flush()
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge [IMAGE]",
+	Short: "Drop images from the cache, so they are resolved again",
+	Long: `Drops the image, or with --prefix all images of a registry or repository, from the cache.
+
+	Overrides are kept.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 1) == (purgePrefix != "") {
+			return fmt.Errorf("either an image or --prefix is required")
+		}
+
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+
+		var purged int
+		if purgePrefix != "" {
+			purged, err = client.PurgeRepository(cmd.Context(), purgePrefix)
+		} else {
+			purged, err = client.Purge(cmd.Context(), args[0])
+		}
+		if err != nil {
+			return fmt.Errorf("purge images: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "purged %d images\n", purged)
+		return nil
+	},
+}
+
+var cacheRefreshCmd = &cobra.Command{
+	Use:   "refresh IMAGE",
+	Short: "Resolve an image again and print its platforms",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := adminClient()
+		if err != nil {
+			return err
+		}
+
+		entry, err := client.Refresh(cmd.Context(), args[0])
+		if err != nil {
+			return fmt.Errorf("refresh image: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", entry.Reference, platforms(*entry))
+		return nil
+	},
+}
+
+func init() {
+	cacheCmd.PersistentFlags().StringVar(&adminURL, "admin-url", adminURL, "URL of the admin API")
+	cachePurgeCmd.Flags().StringVar(&purgePrefix, "prefix", purgePrefix, "Purge all images of this registry or repository")
+
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cachePurgeCmd)
+	cacheCmd.AddCommand(cacheRefreshCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+var exportOutput = ""
+
+var exportCmd = &cobra.Command{
+	Use:   "export [IMAGE...]",
+	Short: "Write the resolved platforms of images as cache snapshot",
+	Long: `Resolves the given images and writes them as cache snapshot, which can be imported into the cache of another installation.
+
+	Without images, all images of the snapshot in --cache-file are exported. This allows to seed the cache of air-gapped clusters.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := configureResources(); err != nil {
+			return err
+		}
+
+		if cacheFile != "" {
+			if _, err := resources.DefaultCache.Load(cmd.Context(), cacheFile); err != nil {
+				return fmt.Errorf("load cache: %w", err)
+			}
+		}
+
+		for _, image := range args {
+			if _, err := resources.DefaultCache.Get(cmd.Context(), image); err != nil {
+				return fmt.Errorf("resolve %s: %w", image, err)
+			}
+		}
+
+		file, err := os.Create(exportOutput)
+		if err != nil {
+			return fmt.Errorf("create output: %w", err)
+		}
+
+		if err := resources.DefaultCache.Export(file, args...); err != nil {
+			file.Close()
+			return err
+		}
+
+		return file.Close()
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Import a cache snapshot into --cache-file",
+	Long: `Merges a snapshot written by export into the snapshot in --cache-file, which the webhook loads at startup.
+
+	Images already in --cache-file are only replaced by ones resolved later.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cacheFile == "" {
+			return fmt.Errorf("import requires --cache-file")
+		}
+
+		if _, err := resources.DefaultCache.Load(cmd.Context(), cacheFile); err != nil {
+			return fmt.Errorf("load cache: %w", err)
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("open snapshot: %w", err)
+		}
+		defer file.Close()
+
+		imported, err := resources.DefaultCache.Import(file)
+		if err != nil {
+			return fmt.Errorf("import %s: %w", args[0], err)
+		}
+
+		if err := resources.DefaultCache.Save(cmd.Context(), cacheFile); err != nil {
+			return fmt.Errorf("save cache: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "imported %d images\n", imported)
+		return nil
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", exportOutput, "File to write the snapshot to")
+	exportCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+}
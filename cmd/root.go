@@ -2,15 +2,24 @@ package cmd
 
 import (
 	"context"
+	"crypto"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/ongy/k8s-auto-arch/internal/admin"
 	"github.com/ongy/k8s-auto-arch/internal/controller"
+	"github.com/ongy/k8s-auto-arch/internal/imagearch"
+	"github.com/ongy/k8s-auto-arch/internal/nodes"
+	"github.com/ongy/k8s-auto-arch/internal/notify"
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/warmer"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
@@ -24,6 +33,9 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 
 	"golang.org/x/exp/slog"
 )
@@ -36,7 +48,58 @@ var (
 	tlsKey       = ""
 	tlsCert      = ""
 
-	port int
+	port                  int
+	pinDigests            = false
+	denyUnsigned          = false
+	suggestTags           = false
+	suggestArchitectures  = []string{}
+	fallbackArchitectures = []string{}
+	overrideFile          = ""
+	overrideReload        = 30 * time.Second
+	ociLayout             = ""
+	ociLayoutRepository   = ""
+	ociLayoutReload       = 30 * time.Second
+	externalURL           = ""
+	externalTimeout       = 5 * time.Second
+	externalCAFile        = ""
+	externalCertFile      = ""
+	externalKeyFile       = ""
+	restrictToNodes       = false
+	denyUnschedulable     = false
+	karpenterNodePools    = false
+	karpenterVersion      = nodes.NodePoolResource.Version
+	provisionableArches   = []string{}
+	archWeights           = ""
+	namespaceWeights      = false
+	capacityWeights       = false
+	archTolerations       = []string{}
+	archLabels            = []string{}
+	archCompat            = []string{}
+	emulations            = []string{}
+	capacityInterval      = time.Minute
+	capacityMinWeight     = 1
+	capacityMaxWeight     = 100
+
+	verifyPlatforms         = string(resources.VerifyNone)
+	dropUnverifiedPlatforms = true
+	emptyArchPolicy         = string(resources.EmptyArchUnconstrained)
+	defaultArch             = ""
+	signatureKeys           = []string{}
+	suggestionTTL           = time.Hour
+	cacheTTL                = resources.DefaultCache.TTL
+	cacheStaleGrace         = resources.DefaultCache.StaleGrace
+	cacheRetention          = resources.DefaultCache.Retention
+	cacheFile               = ""
+	cacheSnapshotInterval   = 5 * time.Minute
+	sharedCache             = false
+	warmCluster             = false
+	warmImages              = ""
+	warmConcurrency         = warmer.Concurrency
+	notifyPort              = 0
+	notifySecretFile        = ""
+	notifyRefresh           = false
+	adminAddress            = ""
+	adminTokenFile          = ""
 )
 
 func initTracer() func(context.Context) error {
@@ -100,6 +163,10 @@ var rootCmd = &cobra.Command{
 		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
 		defer stop()
 
+		if err := configureResources(); err != nil {
+			return err
+		}
+
 		if collectorURL != "" {
 			if _, err := initMeter(ctx); err != nil {
 				return fmt.Errorf("initMeter: %w", err)
@@ -109,7 +176,193 @@ var rootCmd = &cobra.Command{
 			defer stopTracer(ctx)
 		}
 
-		return runWebhookServer(ctx)
+		controller.PinDigests = pinDigests
+		controller.DenyUnsigned = denyUnsigned
+		controller.DenyUnschedulable = denyUnschedulable
+		controller.SuggestTags = suggestTags
+		controller.SuggestArchitectures = suggestArchitectures
+		resolver, err := resolverChain(ctx)
+		if err != nil {
+			return err
+		}
+		controller.Resolver = resolver
+
+		weights, err := controller.ParseWeights(archWeights)
+		if err != nil {
+			return fmt.Errorf("--arch-weights: %w", err)
+		}
+		controller.ArchitectureWeights = weights
+
+		if len(archLabels) > 0 {
+			labels := []controller.ArchitectureLabel{}
+			for _, value := range archLabels {
+				label, err := controller.ParseArchitectureLabel(value)
+				if err != nil {
+					return fmt.Errorf("--arch-label: %w", err)
+				}
+				labels = append(labels, label)
+			}
+			controller.ArchitectureLabels = labels
+		}
+
+		for _, value := range archCompat {
+			compat, err := controller.ParseCompatibility(value)
+			if err != nil {
+				return fmt.Errorf("--arch-compat: %w", err)
+			}
+			controller.Compatibilities = append(controller.Compatibilities, compat)
+		}
+
+		for _, value := range archTolerations {
+			arch, toleration, err := controller.ParseToleration(value)
+			if err != nil {
+				return fmt.Errorf("--arch-toleration: %w", err)
+			}
+			controller.ArchitectureTolerations[arch] = append(controller.ArchitectureTolerations[arch], toleration)
+		}
+
+		if namespaceWeights {
+			config, err := kubeConfig()
+			if err != nil {
+				return err
+			}
+			client, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return fmt.Errorf("create kubernetes client: %w", err)
+			}
+
+			factory := informers.NewSharedInformerFactory(client, 10*time.Minute)
+			controller.Namespaces = factory.Core().V1().Namespaces().Lister()
+			factory.Start(ctx.Done())
+		}
+
+		if (denyUnschedulable || karpenterNodePools || len(provisionableArches) > 0 || capacityWeights || len(emulations) > 0) && !restrictToNodes {
+			return fmt.Errorf("--deny-unschedulable, --karpenter-nodepools, --provisionable-arch, --capacity-weights and --emulation require --restrict-to-nodes")
+		}
+		for _, value := range emulations {
+			emulation, err := controller.ParseEmulation(value)
+			if err != nil {
+				return fmt.Errorf("--emulation: %w", err)
+			}
+			controller.Emulations = append(controller.Emulations, emulation)
+		}
+		if capacityMinWeight < 0 || capacityMaxWeight > 100 || capacityMinWeight > capacityMaxWeight {
+			return fmt.Errorf("--capacity-min-weight and --capacity-max-weight have to be between 0 and 100")
+		}
+		if restrictToNodes {
+			config, err := kubeConfig()
+			if err != nil {
+				return err
+			}
+			client, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return fmt.Errorf("create kubernetes client: %w", err)
+			}
+
+			inventory := nodes.NewInventory(client)
+			for _, arch := range provisionableArches {
+				inventory.Provisionable[arch] = true
+			}
+			if karpenterNodePools {
+				poolClient, err := dynamic.NewForConfig(config)
+				if err != nil {
+					return fmt.Errorf("create dynamic client: %w", err)
+				}
+				nodes.NodePoolResource.Version = karpenterVersion
+				inventory.WatchNodePools(poolClient)
+			}
+
+			if capacityWeights {
+				inventory.WatchPods()
+				capacity := nodes.NewCapacityWeights(inventory)
+				capacity.MinWeight = int32(capacityMinWeight)
+				capacity.MaxWeight = int32(capacityMaxWeight)
+				controller.Capacity = capacity
+				go capacity.Run(ctx, capacityInterval)
+			}
+
+			if len(controller.Emulations) > 0 {
+				factory := informers.NewSharedInformerFactory(client, 10*time.Minute)
+				controller.RuntimeClasses = factory.Node().V1().RuntimeClasses().Lister()
+				factory.Start(ctx.Done())
+			}
+
+			controller.Nodes = inventory
+			go inventory.Run(ctx)
+		}
+
+		if sharedCache {
+			config, err := kubeConfig()
+			if err != nil {
+				return err
+			}
+			client, err := dynamic.NewForConfig(config)
+			if err != nil {
+				return fmt.Errorf("create dynamic client: %w", err)
+			}
+
+			store := imagearch.NewStore(client, resources.DefaultCache)
+			resources.DefaultCache.Publish = store.Publish
+			resources.DefaultCache.Unpublish = store.Unpublish
+			go store.Run(ctx)
+		}
+
+		if notifyPort != 0 {
+			if err := configureNotify(); err != nil {
+				return err
+			}
+			go func() {
+				if err := runNotifyServer(ctx); err != nil {
+					slog.ErrorContext(ctx, "Notification server failed", "err", err)
+				}
+			}()
+		}
+
+		if adminAddress != "" {
+			if adminTokenFile == "" {
+				return fmt.Errorf("--admin-address requires --admin-token-file")
+			}
+			token, err := readSecret(adminTokenFile)
+			if err != nil {
+				return fmt.Errorf("--admin-token-file: %w", err)
+			}
+			go func() {
+				if err := runAdminServer(ctx, token); err != nil {
+					slog.ErrorContext(ctx, "Admin server failed", "err", err)
+				}
+			}()
+		}
+
+		// The warmer only resolves what the snapshot doesn't cover.
+		if cacheFile != "" {
+			imported, err := resources.DefaultCache.Load(ctx, cacheFile)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to load cache snapshot, starting cold", "path", cacheFile, "err", err)
+			} else {
+				slog.InfoContext(ctx, "Loaded cache snapshot", "path", cacheFile, "images", imported)
+			}
+		}
+
+		warmer.Concurrency = warmConcurrency
+		warmer.Resolver = controller.Resolver
+		if warmCluster || warmImages != "" {
+			go warmCache(ctx)
+		}
+
+		if cacheFile == "" {
+			return runWebhookServer(ctx)
+		}
+
+		saved := make(chan struct{})
+		go func() {
+			defer close(saved)
+			resources.DefaultCache.SaveEvery(ctx, cacheFile, cacheSnapshotInterval)
+		}()
+
+		err = runWebhookServer(ctx)
+		stop()
+		<-saved
+		return err
 	},
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{AddSource: true})
@@ -127,10 +380,209 @@ func Execute() {
 
 func init() {
 	rootCmd.Flags().IntVar(&port, "port", 8080, "Port to listen on for HTTPS traffic")
+	rootCmd.Flags().BoolVar(&pinDigests, "pin-digests", pinDigests, "Rewrite container images to the digest inspected during admission")
+	rootCmd.Flags().BoolVar(&denyUnsigned, "deny-unsigned", denyUnsigned, "Deny pods with unsigned images instead of admitting them without affinity")
+	rootCmd.Flags().BoolVar(&suggestTags, "suggest-tags", suggestTags, "Warn about images limiting the pod's architectures and suggest tags that support more")
+	rootCmd.Flags().StringSliceVar(&suggestArchitectures, "suggest-arch", suggestArchitectures, "Architectures to suggest tags for. Defaults to the ones supported by the pod's other images")
+	rootCmd.Flags().StringSliceVar(&fallbackArchitectures, "fallback-arch", fallbackArchitectures, "Architectures assumed for images that can't be resolved, \"*\" for unconstrained. By default, such pods fail admission")
+	rootCmd.Flags().StringVar(&overrideFile, "override-file", overrideFile, "YAML file with image patterns whose platforms are set by hand instead of asking the registry")
+	rootCmd.Flags().DurationVar(&overrideReload, "override-reload-interval", overrideReload, "How often the override file is checked for changes")
+	rootCmd.Flags().StringVar(&ociLayout, "oci-layout", ociLayout, "OCI image layout directory to resolve images from before asking the registry, e.g. for air-gapped clusters")
+	rootCmd.Flags().StringVar(&ociLayoutRepository, "oci-layout-repository", ociLayoutRepository, "Repository of the entries in the OCI image layout that are named only by a tag, e.g. registry.local/org/app")
+	rootCmd.Flags().DurationVar(&ociLayoutReload, "oci-layout-reload-interval", ociLayoutReload, "How often the index of the OCI image layout is checked for changes")
+	rootCmd.Flags().StringVar(&externalURL, "external-resolver-url", externalURL, "URL of an HTTP endpoint that is asked for the platforms of images before the registry")
+	rootCmd.Flags().DurationVar(&externalTimeout, "external-resolver-timeout", externalTimeout, "Timeout of requests to the external resolver")
+	rootCmd.Flags().StringVar(&externalCAFile, "external-resolver-ca-file", externalCAFile, "PEM file with the CA that signed the external resolver's certificate. Defaults to the system CAs")
+	rootCmd.Flags().StringVar(&externalCertFile, "external-resolver-cert-file", externalCertFile, "PEM file with the client certificate to authenticate to the external resolver with")
+	rootCmd.Flags().StringVar(&externalKeyFile, "external-resolver-key-file", externalKeyFile, "PEM file with the key of --external-resolver-cert-file")
+	rootCmd.Flags().BoolVar(&restrictToNodes, "restrict-to-nodes", restrictToNodes, "Only constrain pods to architectures the cluster has nodes for, and leave pods that run on all of them alone")
+	rootCmd.Flags().BoolVar(&denyUnschedulable, "deny-unschedulable", denyUnschedulable, "Deny pods whose images support none of the nodes' architectures, or that no node can host, instead of only warning. Requires --restrict-to-nodes")
+	rootCmd.Flags().BoolVar(&karpenterNodePools, "karpenter-nodepools", karpenterNodePools, "Treat the architectures Karpenter NodePools can provision as available")
+	rootCmd.Flags().StringVar(&karpenterVersion, "karpenter-api-version", karpenterVersion, "API version of the Karpenter NodePools, e.g. v1beta1 for Karpenter before v1")
+	rootCmd.Flags().StringSliceVar(&provisionableArches, "provisionable-arch", provisionableArches, "Architectures an autoscaler can add nodes for, treated as available without nodes")
+	rootCmd.Flags().StringVar(&archWeights, "arch-weights", archWeights, "Preferences for architectures of multi-arch pods, e.g. arm64=50,amd64=10. Weights are between 0 and 100")
+	rootCmd.Flags().BoolVar(&namespaceWeights, "namespace-weights", namespaceWeights, "Let the k8s-auto-arch.ongy.net/arch-weights annotation of namespaces override --arch-weights")
+	rootCmd.Flags().StringArrayVar(&archLabels, "arch-label", archLabels, "Node label to select architectures with instead of kubernetes.io/arch, with optional translations of OCI architectures to its values, e.g. kubernetes.io/arch:arm=armhf or node.kubernetes.io/instance-family:arm64=c7g,amd64=m6i. Can be repeated, nodes match any of the labels")
+	rootCmd.Flags().StringArrayVar(&archCompat, "arch-compat", archCompat, "Image architectures nodes of an architecture run besides their own, optionally only with a node label, e.g. amd64=386 or arm64=arm:example.com/compat32=true. Can be repeated")
+	rootCmd.Flags().StringArrayVar(&archTolerations, "arch-toleration", archTolerations, "Toleration added to pods that can run on the architecture, e.g. arm64=arch=arm64:NoSchedule for nodes tainted with arch=arm64:NoSchedule. Can be repeated")
+	rootCmd.Flags().StringArrayVar(&emulations, "emulation", emulations, "RuntimeClass that emulates an architecture on nodes of others, for pods no node runs natively, e.g. amd64=emulated-amd64:arm64. Requires --restrict-to-nodes. Can be repeated")
+	rootCmd.Flags().BoolVar(&capacityWeights, "capacity-weights", capacityWeights, "Derive the preferences for architectures of multi-arch pods from the free CPU and memory of their nodes instead of --arch-weights. Requires --restrict-to-nodes")
+	rootCmd.Flags().DurationVar(&capacityInterval, "capacity-interval", capacityInterval, "How often --capacity-weights are recomputed")
+	rootCmd.Flags().IntVar(&capacityMinWeight, "capacity-min-weight", capacityMinWeight, "Weight of architectures without free capacity")
+	rootCmd.Flags().IntVar(&capacityMaxWeight, "capacity-max-weight", capacityMaxWeight, "Weight of architectures with all their capacity free")
+	rootCmd.Flags().BoolVar(&sharedCache, "shared-cache", sharedCache, "Share resolved images with other replicas through ImageArchitecture resources")
+	rootCmd.Flags().BoolVar(&warmCluster, "warm-cluster", warmCluster, "Resolve the images of all pods and workloads in the cluster at startup")
+	rootCmd.Flags().StringVar(&warmImages, "warm-images", warmImages, "File with images to resolve at startup, one per line")
+	rootCmd.Flags().IntVar(&warmConcurrency, "warm-concurrency", warmConcurrency, "How many images are resolved at once while warming the cache")
+	rootCmd.Flags().IntVar(&notifyPort, "notify-port", notifyPort, "Port to listen on for registry push notifications. 0 disables them")
+	rootCmd.Flags().StringVar(&notifySecretFile, "notify-secret-file", notifySecretFile, "File with the secret registries send in the Authorization header of notifications")
+	rootCmd.Flags().BoolVar(&notifyRefresh, "notify-refresh", notifyRefresh, "Resolve pushed images again right away instead of only invalidating them")
+	rootCmd.Flags().StringVar(&adminAddress, "admin-address", adminAddress, "Address to serve the cache admin API on, e.g. localhost:8081. Empty disables it")
+	rootCmd.Flags().DurationVar(&cacheSnapshotInterval, "cache-snapshot-interval", cacheSnapshotInterval, "How often the cache is snapshotted to --cache-file")
 	rootCmd.PersistentFlags().StringVar(&collectorURL, "otlp_collector", "", "Set the open telemetry collector URI")
 
 	rootCmd.PersistentFlags().StringVar(&tlsKey, "tls-key", "", "")
 	rootCmd.PersistentFlags().StringVar(&tlsCert, "tls-crt", "", "")
+
+	rootCmd.PersistentFlags().StringVar(&verifyPlatforms, "verify-platforms", verifyPlatforms, "Verify the platforms of image indexes before trusting them (none, manifest or config)")
+	rootCmd.PersistentFlags().BoolVar(&dropUnverifiedPlatforms, "drop-unverified-platforms", dropUnverifiedPlatforms, "Drop platforms that fail verification instead of only warning about them")
+	rootCmd.PersistentFlags().StringVar(&emptyArchPolicy, "empty-arch-policy", emptyArchPolicy, "How to handle images without architecture in their config (unconstrained, default or inspect)")
+	rootCmd.PersistentFlags().StringVar(&defaultArch, "default-arch", defaultArch, "Architecture assumed for images without architecture in their config")
+	rootCmd.PersistentFlags().DurationVar(&suggestionTTL, "suggestion-ttl", suggestionTTL, "How long tag lists and the platforms of suggested tags are cached")
+	rootCmd.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", cacheTTL, "How long resolved platforms of images are used without asking the registry again. 0 disables the cache")
+	rootCmd.PersistentFlags().DurationVar(&cacheStaleGrace, "cache-stale-grace", cacheStaleGrace, "How long expired platforms are still used while they are refreshed in the background")
+	rootCmd.PersistentFlags().DurationVar(&cacheRetention, "cache-retention", cacheRetention, "How long platforms are kept after --cache-stale-grace, as fallback if resolving the image fails")
+	rootCmd.PersistentFlags().StringVar(&cacheFile, "cache-file", cacheFile, "File the cache is snapshotted to and loaded from at startup. Must be on a writable volume")
+	rootCmd.PersistentFlags().StringVar(&adminTokenFile, "admin-token-file", adminTokenFile, "File with the token that authenticates requests to the admin API")
+	rootCmd.PersistentFlags().StringArrayVar(&signatureKeys, "signature-key", signatureKeys, "PEM file with public keys trusted to sign images with cosign. Can be repeated")
+}
+
+func configureResources() error {
+	mode, err := resources.ParseVerifyMode(verifyPlatforms)
+	if err != nil {
+		return fmt.Errorf("--verify-platforms: %w", err)
+	}
+	resources.PlatformVerification = mode
+	resources.DropUnverifiedPlatforms = dropUnverifiedPlatforms
+
+	policy, err := resources.ParseEmptyArchPolicy(emptyArchPolicy)
+	if err != nil {
+		return fmt.Errorf("--empty-arch-policy: %w", err)
+	}
+	if policy == resources.EmptyArchDefault && defaultArch == "" {
+		return fmt.Errorf("--empty-arch-policy=%s requires --default-arch", policy)
+	}
+	resources.EmptyArchitecture = policy
+	resources.DefaultArchitecture = defaultArch
+
+	resources.SignatureKeys = []crypto.PublicKey{}
+	for _, path := range signatureKeys {
+		keys, err := resources.LoadPublicKeys(path)
+		if err != nil {
+			return fmt.Errorf("--signature-key: %w", err)
+		}
+		resources.SignatureKeys = append(resources.SignatureKeys, keys...)
+	}
+
+	resources.SetSuggestionTTL(suggestionTTL)
+	resources.DefaultCache.TTL = cacheTTL
+	resources.DefaultCache.StaleGrace = cacheStaleGrace
+	resources.DefaultCache.Retention = cacheRetention
+
+	return nil
+}
+
+// resolverChain builds the chain the controller resolves images through. The
+// override file is reloaded until ctx is done.
+func resolverChain(ctx context.Context) (resources.Resolver, error) {
+	chain := resources.Chain{}
+
+	if overrideFile != "" {
+		overrides, err := resources.LoadOverrides(ctx, overrideFile)
+		if err != nil {
+			return nil, fmt.Errorf("--override-file: %w", err)
+		}
+		go overrides.ReloadEvery(ctx, overrideReload)
+		chain = append(chain, overrides)
+	}
+
+	if ociLayout != "" {
+		layout, err := resources.LoadLayout(ctx, ociLayout, ociLayoutRepository)
+		if err != nil {
+			return nil, fmt.Errorf("--oci-layout: %w", err)
+		}
+		go layout.ReloadEvery(ctx, ociLayoutReload)
+		chain = append(chain, layout)
+	}
+
+	if externalURL != "" {
+		client, err := resources.ExternalClient(externalTimeout, externalCAFile, externalCertFile, externalKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("--external-resolver-url: %w", err)
+		}
+		chain = append(chain, resources.NewExternal(externalURL, client))
+	}
+
+	chain = append(chain, resources.DefaultCache)
+
+	if len(fallbackArchitectures) == 1 && fallbackArchitectures[0] == "*" {
+		chain = append(chain, &resources.Fallback{})
+	} else if len(fallbackArchitectures) > 0 {
+		fallback := &resources.Fallback{Architectures: map[string]bool{}}
+		for _, arch := range fallbackArchitectures {
+			fallback.Architectures[arch] = true
+		}
+		chain = append(chain, fallback)
+	}
+
+	return chain, nil
+}
+
+// readSecret reads a secret from a file, e.g. a mounted kubernetes secret.
+func readSecret(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+
+	return secret, nil
+}
+
+func configureNotify() error {
+	if notifySecretFile == "" {
+		return fmt.Errorf("--notify-port requires --notify-secret-file")
+	}
+
+	secret, err := readSecret(notifySecretFile)
+	if err != nil {
+		return fmt.Errorf("--notify-secret-file: %w", err)
+	}
+	notify.Secret = secret
+	notify.Refresh = notifyRefresh
+
+	return nil
+}
+
+func clusterImages(ctx context.Context) ([]string, error) {
+	config, err := kubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("create kubernetes client: %w", err)
+	}
+
+	return warmer.ClusterImages(ctx, client)
+}
+
+// warmCache resolves the images configured with --warm-cluster and
+// --warm-images. The webhook already serves requests while this runs.
+func warmCache(ctx context.Context) {
+	images := []string{}
+	if warmImages != "" {
+		listed, err := warmer.ReadImages(warmImages)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read images to warm", "path", warmImages, "err", err)
+		}
+		images = append(images, listed...)
+	}
+
+	if warmCluster {
+		listed, err := clusterImages(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to list images in cluster", "err", err)
+		}
+		images = append(images, listed...)
+	}
+
+	failed := warmer.Warm(ctx, images)
+	slog.InfoContext(ctx, "Warmed cache", "images", len(images), "failed", failed)
 }
 
 func runWebhookServer(ctx context.Context) error {
@@ -140,15 +592,46 @@ func runWebhookServer(ctx context.Context) error {
 		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
 
+	return serve(ctx, &server, tlsKey != "")
+}
+
+// runNotifyServer serves registry notifications. It's separate from the
+// webhook, so registries don't need access to the admission path.
+func runNotifyServer(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/", otelhttp.NewHandler(http.HandlerFunc(notify.HandleRequest), "notify"))
+	server := http.Server{
+		Addr:     fmt.Sprintf(":%d", notifyPort),
+		Handler:  mux,
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+	}
+
+	return serve(ctx, &server, tlsKey != "")
+}
+
+// runAdminServer serves the cache admin API. It's meant to be reached through
+// a port-forward, so it doesn't use TLS.
+func runAdminServer(ctx context.Context, token string) error {
+	server := http.Server{
+		Addr:     adminAddress,
+		Handler:  otelhttp.NewHandler(admin.NewHandler(resources.DefaultCache, token), "admin"),
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+	}
+
+	return serve(ctx, &server, false)
+}
+
+// serve runs the server until ctx is done.
+func serve(ctx context.Context, server *http.Server, useTLS bool) error {
 	go func() {
 		<-ctx.Done()
 
 		if err := server.Shutdown(context.Background()); err != nil {
-			slog.ErrorContext(ctx, "Failed to shutdown server", "err", err, "port", port)
+			slog.ErrorContext(ctx, "Failed to shutdown server", "err", err, "addr", server.Addr)
 		}
 	}()
 
-	if tlsKey != "" {
+	if useTLS {
 		if err := server.ListenAndServeTLS(tlsCert, tlsKey); err != nil {
 			if !errors.Is(err, http.ErrServerClosed) {
 				return fmt.Errorf("ListenAndServeTLs: %w", err)
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+)
+
+var suggestArches = []string{}
+
+var suggestCmd = &cobra.Command{
+	Use:   "suggest IMAGE",
+	Short: "Suggest tags of an image that support the given architectures",
+	Long: `Lists the tags of the image's repository and resolves the ones closest to the requested tag.
+
+	Prints the closest tags that support all architectures passed with --arch, together with all architectures they support.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := configureResources(); err != nil {
+			return err
+		}
+
+		suggestions, err := resources.SuggestTags(cmd.Context(), resources.DefaultCache, args[0], suggestArches)
+		if err != nil {
+			return fmt.Errorf("suggest tags: %w", err)
+		}
+
+		for _, suggestion := range suggestions {
+			arches := util.Keys(suggestion.Architectures)
+			slices.Sort(arches)
+			fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", suggestion.Reference, strings.Join(arches, ","))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	suggestCmd.Flags().StringSliceVar(&suggestArches, "arch", suggestArches, "Architectures the suggested tags have to support")
+	suggestCmd.MarkFlagRequired("arch")
+
+	rootCmd.AddCommand(suggestCmd)
+}
@@ -0,0 +1,161 @@
+// Package admin serves an API to inspect and manage the image cache, and
+// implements a client for it.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+)
+
+// Entry is a cached image as returned by the API.
+type Entry struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest,omitempty"`
+	// Absent for images that don't constrain the architecture.
+	Platforms []string `json:"platforms,omitempty"`
+	// The platforms as the source reported them, if they were normalized.
+	ReportedPlatforms []string  `json:"reportedPlatforms,omitempty"`
+	Source            string    `json:"source,omitempty"`
+	ResolvedAt        time.Time `json:"resolvedAt"`
+	Age               string    `json:"age"`
+}
+
+// PurgeResult is returned when images were purged.
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+func toEntry(image *resources.Image, now time.Time) Entry {
+	entry := Entry{
+		Reference:         image.Reference,
+		Digest:            image.Digest,
+		Source:            image.Source,
+		ReportedPlatforms: image.Reported,
+		ResolvedAt:        image.ResolvedAt,
+		Age:               now.Sub(image.ResolvedAt).Round(time.Second).String(),
+	}
+	if image.Architectures != nil {
+		entry.Platforms = util.Keys(image.Architectures)
+		slices.Sort(entry.Platforms)
+	}
+
+	return entry
+}
+
+type handler struct {
+	cache *resources.Cache
+	token string
+}
+
+// NewHandler returns the API for the cache. Requests have to send the token as
+// bearer token.
+func NewHandler(cache *resources.Cache, token string) http.Handler {
+	h := &handler{cache: cache, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images", h.images)
+	mux.HandleFunc("/images/refresh", h.refresh)
+
+	return h.authorized(mux)
+}
+
+func (h *handler) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if h.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write response", "err", err)
+	}
+}
+
+// images lists the cached images on GET, and purges them on DELETE. Purges
+// take either ref for a single image or prefix for a registry or repository.
+// They are unpublished too, so other replicas drop the images as well.
+func (h *handler) images(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("").Start(r.Context(), "admin.images", trace.WithAttributes(attribute.String("method", r.Method)))
+	defer span.End()
+
+	switch r.Method {
+	case http.MethodGet:
+		now := time.Now()
+		entries := []Entry{}
+		for _, image := range h.cache.Images() {
+			entries = append(entries, toEntry(image, now))
+		}
+		writeJSON(w, r, entries)
+
+	case http.MethodDelete:
+		ref := r.URL.Query().Get("ref")
+		prefix := r.URL.Query().Get("prefix")
+
+		result := PurgeResult{}
+		switch {
+		case ref != "" && prefix == "":
+			if h.cache.Invalidate(ref) {
+				result.Purged = 1
+			}
+		case prefix != "" && ref == "":
+			result.Purged = h.cache.InvalidateRepository(prefix)
+		default:
+			http.Error(w, "exactly one of ref and prefix is required", http.StatusBadRequest)
+			return
+		}
+
+		slog.InfoContext(ctx, "Purged images", "ref", ref, "prefix", prefix, "purged", result.Purged)
+		writeJSON(w, r, result)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// refresh resolves the image given in ref again and returns it.
+func (h *handler) refresh(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("").Start(r.Context(), "admin.refresh")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		http.Error(w, "ref is required", http.StatusBadRequest)
+		return
+	}
+
+	h.cache.Invalidate(ref)
+	image, err := h.cache.Get(ctx, ref)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(w, fmt.Sprintf("resolve %s: %v", ref, err), http.StatusBadGateway)
+		return
+	}
+
+	slog.InfoContext(ctx, "Refreshed image", "container", ref)
+	writeJSON(w, r, toEntry(image, time.Now()))
+}
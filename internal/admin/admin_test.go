@@ -0,0 +1,220 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/ongy/k8s-auto-arch/internal/imagearch"
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+func newServer(t *testing.T) (*Client, *resources.Cache) {
+	resolve := resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		if ref == "registry.local/broken:1.0" {
+			return nil, errors.New("registry down")
+		}
+		return &resources.Image{Reference: ref, Architectures: map[string]bool{"arm64": true}, Source: resources.SourceIndex}, nil
+	})
+
+	cache := resources.NewCache(resolve)
+	resolvedAt := time.Now().Add(-time.Minute)
+	for _, ref := range []string{"registry.local/org/image:1.0", "registry.local/org/other:1.0", "registry.local/unconstrained:1.0"} {
+		image := &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}, Source: resources.SourceConfig, ResolvedAt: resolvedAt}
+		if ref == "registry.local/unconstrained:1.0" {
+			image.Architectures = nil
+		}
+		cache.Put(image)
+	}
+
+	server := httptest.NewServer(NewHandler(cache, "token"))
+	t.Cleanup(server.Close)
+
+	return &Client{URL: server.URL, Token: "token"}, cache
+}
+
+func TestList(t *testing.T) {
+	client, _ := newServer(t)
+
+	entries, err := client.List(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("got != want: %d != 3 entries", len(entries))
+	}
+	if entries[0].Reference != "registry.local/org/image:1.0" || !slices.Equal(entries[0].Platforms, []string{"amd64"}) || entries[0].Age != "1m0s" {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+	if entries[2].Platforms != nil {
+		t.Errorf("Expected unconstrained entry, got: %+v", entries[2])
+	}
+}
+
+func TestPurge(t *testing.T) {
+	testCases := []struct {
+		name     string
+		purge    func(*Client) (int, error)
+		purged   int
+		expected []string
+	}{
+		{
+			name:     "ref",
+			purge:    func(c *Client) (int, error) { return c.Purge(context.Background(), "registry.local/org/image:1.0") },
+			purged:   1,
+			expected: []string{"registry.local/org/other:1.0", "registry.local/unconstrained:1.0"},
+		},
+		{
+			name:     "missing",
+			purge:    func(c *Client) (int, error) { return c.Purge(context.Background(), "registry.local/org/missing:1.0") },
+			purged:   0,
+			expected: []string{"registry.local/org/image:1.0", "registry.local/org/other:1.0", "registry.local/unconstrained:1.0"},
+		},
+		{
+			name:     "prefix",
+			purge:    func(c *Client) (int, error) { return c.PurgeRepository(context.Background(), "registry.local/org") },
+			purged:   2,
+			expected: []string{"registry.local/unconstrained:1.0"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, cache := newServer(t)
+
+			purged, err := testCase.purge(client)
+			if err != nil {
+				t.Fatalf("Failed to purge: %v", err)
+			}
+			if purged != testCase.purged {
+				t.Errorf("got != want: %d != %d purged", purged, testCase.purged)
+			}
+
+			got := []string{}
+			for _, image := range cache.Images() {
+				got = append(got, image.Reference)
+			}
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	client, _ := newServer(t)
+
+	entry, err := client.Refresh(context.Background(), "registry.local/org/image:1.0")
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if !slices.Equal(entry.Platforms, []string{"arm64"}) {
+		t.Errorf("got != want: %v != [arm64]", entry.Platforms)
+	}
+
+	if _, err := client.Refresh(context.Background(), "registry.local/broken:1.0"); err == nil {
+		t.Errorf("Expected refresh of broken image to fail")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	testCases := []struct {
+		name          string
+		authorization string
+	}{
+		{name: "missing"},
+		{name: "wrong", authorization: "Bearer wrong"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/images", nil)
+			request.Header.Set("Authorization", testCase.authorization)
+			recorder := httptest.NewRecorder()
+
+			NewHandler(resources.NewCache(nil), "token").ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusUnauthorized {
+				t.Errorf("got != want: %d != %d", recorder.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestPurgeShared(t *testing.T) {
+	cache := resources.NewCache(resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		return &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}, Source: resources.SourceIndex}, nil
+	}))
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{imagearch.Resource: imagearch.Kind + "List"})
+	store := imagearch.NewStore(client, cache)
+	cache.Publish = store.Publish
+	cache.Unpublish = store.Unpublish
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Run(ctx)
+
+	server := httptest.NewServer(NewHandler(cache, "token"))
+	defer server.Close()
+	admin := &Client{URL: server.URL, Token: "token"}
+
+	shared := func() []string {
+		list, err := client.Resource(imagearch.Resource).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("Failed to list ImageArchitectures: %v", err)
+		}
+		names := []string{}
+		for _, item := range list.Items {
+			names = append(names, item.GetName())
+		}
+		slices.Sort(names)
+		return names
+	}
+	waitFor := func(want []string) {
+		slices.Sort(want)
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(shared(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("got != want: %v != %v", shared(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	refs := []string{"registry.local/org/image:1.0", "registry.local/org/other:1.0", "registry.local/unconstrained:1.0"}
+	for _, ref := range refs {
+		if _, err := cache.Get(ctx, ref); err != nil {
+			t.Fatalf("Failed to resolve %s: %v", ref, err)
+		}
+	}
+	waitFor([]string{imagearch.Name(refs[0]), imagearch.Name(refs[1]), imagearch.Name(refs[2])})
+
+	purged, err := admin.PurgeRepository(ctx, "registry.local/org")
+	if err != nil {
+		t.Fatalf("Failed to purge: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("got != want: %d != 2 purged", purged)
+	}
+
+	// Purged images are deleted for all replicas.
+	waitFor([]string{imagearch.Name(refs[2])})
+	got := []string{}
+	for _, image := range cache.Images() {
+		got = append(got, image.Reference)
+	}
+	if want := refs[2:]; !slices.Equal(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
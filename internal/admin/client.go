@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to the API served by NewHandler.
+type Client struct {
+	URL   string
+	Token string
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, result any) error {
+	target := strings.TrimSuffix(c.URL, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the cached images.
+func (c *Client) List(ctx context.Context) ([]Entry, error) {
+	entries := []Entry{}
+	if err := c.do(ctx, http.MethodGet, "/images", nil, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Purge drops a single image from the cache.
+func (c *Client) Purge(ctx context.Context, ref string) (int, error) {
+	result := PurgeResult{}
+	if err := c.do(ctx, http.MethodDelete, "/images", url.Values{"ref": {ref}}, &result); err != nil {
+		return 0, err
+	}
+
+	return result.Purged, nil
+}
+
+// PurgeRepository drops all images of a registry or repository from the cache.
+func (c *Client) PurgeRepository(ctx context.Context, prefix string) (int, error) {
+	result := PurgeResult{}
+	if err := c.do(ctx, http.MethodDelete, "/images", url.Values{"prefix": {prefix}}, &result); err != nil {
+		return 0, err
+	}
+
+	return result.Purged, nil
+}
+
+// Refresh resolves the image again.
+func (c *Client) Refresh(ctx context.Context, ref string) (*Entry, error) {
+	entry := &Entry{}
+	if err := c.do(ctx, http.MethodPost, "/images/refresh", url.Values{"ref": {ref}}, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
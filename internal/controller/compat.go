@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Compatibility lets nodes of an architecture run images of other ones, e.g.
+// arm64 nodes 32 bit arm images.
+type Compatibility struct {
+	NodeArch string
+	// Architectures are the image architectures the nodes run besides their
+	// own.
+	Architectures []string
+	// Label restricts the compatibility to nodes with the label, and Value to
+	// the ones where it has the value, if set.
+	Label, Value string
+}
+
+// Compatibilities extend the nodes a pod can run on beyond its architectures.
+var Compatibilities = []Compatibility{}
+
+// ParseCompatibility parses a node architecture, the image architectures it
+// runs and an optional node label, e.g. "arm64=arm" or
+// "arm64=arm:example.com/compat32=true".
+func ParseCompatibility(value string) (Compatibility, error) {
+	spec, gate, gated := strings.Cut(value, ":")
+	nodeArch, arches, ok := strings.Cut(spec, "=")
+	nodeArch = strings.TrimSpace(nodeArch)
+	if !ok || nodeArch == "" {
+		return Compatibility{}, fmt.Errorf("'%s' isn't nodearch=arch[,arch][:label[=value]]", value)
+	}
+
+	compat := Compatibility{NodeArch: nodeArch}
+	for _, arch := range strings.Split(arches, ",") {
+		if arch = strings.TrimSpace(arch); arch != "" {
+			compat.Architectures = append(compat.Architectures, arch)
+		}
+	}
+	if len(compat.Architectures) == 0 {
+		return Compatibility{}, fmt.Errorf("'%s' has no image architectures", value)
+	}
+
+	if gated {
+		label, labelValue, _ := strings.Cut(gate, "=")
+		compat.Label = strings.TrimSpace(label)
+		compat.Value = strings.TrimSpace(labelValue)
+		if compat.Label == "" {
+			return Compatibility{}, fmt.Errorf("'%s' has no label", value)
+		}
+	}
+
+	return compat, nil
+}
+
+// runs returns whether the nodes run one of the arches.
+func (c Compatibility) runs(arches []string) bool {
+	for _, arch := range c.Architectures {
+		if slices.Contains(arches, arch) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requirement returns the requirement on the label of the nodes.
+func (c Compatibility) requirement() corev1.NodeSelectorRequirement {
+	if c.Value == "" {
+		return corev1.NodeSelectorRequirement{Key: c.Label, Operator: corev1.NodeSelectorOpExists}
+	}
+
+	return corev1.NodeSelectorRequirement{Key: c.Label, Operator: corev1.NodeSelectorOpIn, Values: []string{c.Value}}
+}
+
+// compatibleArches returns the node architectures that run the arches, other
+// than the arches themselves. With gated, it includes the ones that only nodes
+// with a label run.
+func compatibleArches(arches []string, gated bool) []string {
+	ret := []string{}
+	for _, compat := range Compatibilities {
+		if compat.Label != "" && !gated {
+			continue
+		}
+		if compat.runs(arches) && !slices.Contains(arches, compat.NodeArch) && !slices.Contains(ret, compat.NodeArch) {
+			ret = append(ret, compat.NodeArch)
+		}
+	}
+
+	return ret
+}
+
+// compatibleTerms returns the terms that select the nodes that run the arches.
+func compatibleTerms(arches []string) []corev1.NodeSelectorTerm {
+	ungated := compatibleArches(arches, false)
+	terms := architectureTerms(append(slices.Clone(arches), ungated...))
+	for _, compat := range Compatibilities {
+		if compat.Label == "" || !compat.runs(arches) || slices.Contains(arches, compat.NodeArch) || slices.Contains(ungated, compat.NodeArch) {
+			continue
+		}
+
+		for _, term := range architectureTerms([]string{compat.NodeArch}) {
+			term.MatchExpressions = append(term.MatchExpressions, compat.requirement())
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseCompatibility(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Compatibility
+		err      bool
+	}{
+		{
+			name:     "simple",
+			input:    "amd64=386",
+			expected: Compatibility{NodeArch: "amd64", Architectures: []string{"386"}},
+		},
+		{
+			name:     "label",
+			input:    "arm64=arm:example.com/compat32",
+			expected: Compatibility{NodeArch: "arm64", Architectures: []string{"arm"}, Label: "example.com/compat32"},
+		},
+		{
+			name:     "label value",
+			input:    "arm64=arm, armel:example.com/compat32=true",
+			expected: Compatibility{NodeArch: "arm64", Architectures: []string{"arm", "armel"}, Label: "example.com/compat32", Value: "true"},
+		},
+		{
+			name:  "format",
+			input: "arm64",
+			err:   true,
+		},
+		{
+			name:  "no arches",
+			input: "arm64=",
+			err:   true,
+		},
+		{
+			name:  "no label",
+			input: "arm64=arm:",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ParseCompatibility(testCase.input)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse compatibility: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestCompatibleTerms(t *testing.T) {
+	Compatibilities = []Compatibility{
+		{NodeArch: "amd64", Architectures: []string{"386"}},
+		{NodeArch: "arm64", Architectures: []string{"arm"}, Label: "example.com/compat32", Value: "true"},
+	}
+	defer func() { Compatibilities = []Compatibility{} }()
+
+	arch := func(values ...string) corev1.NodeSelectorRequirement {
+		return corev1.NodeSelectorRequirement{Key: corev1.LabelArchStable, Operator: corev1.NodeSelectorOpIn, Values: values}
+	}
+	compat32 := corev1.NodeSelectorRequirement{Key: "example.com/compat32", Operator: corev1.NodeSelectorOpIn, Values: []string{"true"}}
+
+	testCases := []struct {
+		name     string
+		input    []string
+		expected []corev1.NodeSelectorTerm
+	}{
+		{
+			name:     "none",
+			input:    []string{"riscv64"},
+			expected: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{arch("riscv64")}}},
+		},
+		{
+			name:     "ungated",
+			input:    []string{"386"},
+			expected: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{arch("386", "amd64")}}},
+		},
+		{
+			name:  "gated",
+			input: []string{"386", "arm"},
+			expected: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{arch("386", "arm", "amd64")}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{arch("arm64"), compat32}},
+			},
+		},
+		{
+			name:     "native",
+			input:    []string{"arm", "arm64"},
+			expected: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{arch("arm", "arm64")}}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := compatibleTerms(testCase.input)
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
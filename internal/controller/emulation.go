@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	nodev1listers "k8s.io/client-go/listers/node/v1"
+)
+
+// Emulation runs images of an architecture on nodes of other ones through a
+// RuntimeClass, e.g. with qemu-user.
+type Emulation struct {
+	Architecture string
+	RuntimeClass string
+	// NodeArchitectures are the architectures of the nodes that have the
+	// RuntimeClass.
+	NodeArchitectures []string
+}
+
+var (
+	// Emulations are used for pods whose architectures have no nodes, if set.
+	Emulations = []Emulation{}
+	// RuntimeClasses looks up the RuntimeClasses of Emulations, if set. The
+	// API server only applies the scheduling constraints and overhead of a
+	// RuntimeClass before webhooks run, so they are added with it.
+	RuntimeClasses nodev1listers.RuntimeClassLister
+)
+
+// ParseEmulation parses an image architecture, the RuntimeClass that emulates
+// it and the node architectures that have it, e.g. "amd64=emulated-amd64:arm64".
+func ParseEmulation(value string) (Emulation, error) {
+	spec, nodeArches, _ := strings.Cut(value, ":")
+	arch, runtimeClass, ok := strings.Cut(spec, "=")
+	emulation := Emulation{Architecture: strings.TrimSpace(arch), RuntimeClass: strings.TrimSpace(runtimeClass)}
+	if !ok || emulation.Architecture == "" || emulation.RuntimeClass == "" {
+		return Emulation{}, fmt.Errorf("'%s' isn't arch=runtimeclass:nodearch[,nodearch]", value)
+	}
+
+	for _, nodeArch := range strings.Split(nodeArches, ",") {
+		if nodeArch = strings.TrimSpace(nodeArch); nodeArch != "" {
+			emulation.NodeArchitectures = append(emulation.NodeArchitectures, nodeArch)
+		}
+	}
+	if len(emulation.NodeArchitectures) == 0 {
+		return Emulation{}, fmt.Errorf("'%s' has no node architectures", value)
+	}
+
+	return emulation, nil
+}
+
+// emulation returns the Emulation for pods that run on none of the
+// architectures of the cluster's nodes, or nil if the pod has nodes, sets its
+// own RuntimeClass or none of its architectures is emulated.
+func emulation(ctx context.Context, pod *corev1.Pod, podArches []string) *Emulation {
+	_, span := otel.Tracer("").Start(ctx, "emulation", trace.WithAttributes(attribute.StringSlice("arches", podArches)))
+	defer span.End()
+
+	if Nodes == nil || pod.Spec.RuntimeClassName != nil {
+		return nil
+	}
+	clusterArches := Nodes.Architectures()
+	if clusterArches == nil {
+		return nil
+	}
+
+	for _, arch := range podArches {
+		if available(clusterArches, arch) {
+			return nil
+		}
+	}
+
+	for i := range Emulations {
+		emulation := &Emulations[i]
+		if !slices.Contains(podArches, emulation.Architecture) {
+			continue
+		}
+		for _, nodeArch := range emulation.NodeArchitectures {
+			if clusterArches[nodeArch] {
+				span.SetAttributes(attribute.String("runtimeClass", emulation.RuntimeClass))
+				return emulation
+			}
+		}
+	}
+
+	return nil
+}
+
+// runtimeClass looks up the RuntimeClass of the emulation. It is nil if
+// RuntimeClasses isn't set. Emulations whose RuntimeClass is missing or
+// selects nodes the pod excludes can't be used.
+func runtimeClass(ctx context.Context, pod *corev1.Pod, emulation *Emulation) (*nodev1.RuntimeClass, error) {
+	_, span := otel.Tracer("").Start(ctx, "runtimeClass", trace.WithAttributes(attribute.String("runtimeClass", emulation.RuntimeClass)))
+	defer span.End()
+
+	if RuntimeClasses == nil {
+		return nil, nil
+	}
+
+	class, err := RuntimeClasses.Get(emulation.RuntimeClass)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("RuntimeClass %s doesn't exist", emulation.RuntimeClass)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get RuntimeClass %s: %w", emulation.RuntimeClass, err)
+	}
+
+	if class.Scheduling != nil {
+		for key, value := range class.Scheduling.NodeSelector {
+			if podValue, ok := pod.Spec.NodeSelector[key]; ok && podValue != value {
+				return nil, fmt.Errorf("RuntimeClass %s selects nodes with %s=%s, but the pod selects %s=%s", emulation.RuntimeClass, key, value, key, podValue)
+			}
+		}
+	}
+
+	return class, nil
+}
+
+// runtimeClassNodeSelector returns the labels the RuntimeClass selects nodes
+// by that the pod doesn't select by yet.
+func runtimeClassNodeSelector(pod *corev1.Pod, class *nodev1.RuntimeClass) map[string]string {
+	if class == nil || class.Scheduling == nil {
+		return nil
+	}
+
+	ret := map[string]string{}
+	for key, value := range class.Scheduling.NodeSelector {
+		if _, ok := pod.Spec.NodeSelector[key]; !ok {
+			ret[key] = value
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+
+	return ret
+}
+
+// runtimeClassTolerations returns the tolerations of the RuntimeClass that
+// neither the pod nor added has yet.
+func runtimeClassTolerations(pod *corev1.Pod, added []corev1.Toleration, class *nodev1.RuntimeClass) []corev1.Toleration {
+	if class == nil || class.Scheduling == nil {
+		return nil
+	}
+
+	present := append(slices.Clone(pod.Spec.Tolerations), added...)
+	ret := []corev1.Toleration{}
+	for _, toleration := range class.Scheduling.Tolerations {
+		toleration := toleration
+		if slices.ContainsFunc(present, func(other corev1.Toleration) bool { return toleration.MatchToleration(&other) }) {
+			continue
+		}
+
+		present = append(present, toleration)
+		ret = append(ret, toleration)
+	}
+
+	return ret
+}
+
+// runtimeClassPatches sets the RuntimeClass of the emulation on the pod, with
+// the node selector and overhead of class, if any.
+func runtimeClassPatches(pod *corev1.Pod, emulation *Emulation, class *nodev1.RuntimeClass) []patchOperation {
+	patches := []patchOperation{{Op: "add", Path: "/spec/runtimeClassName", Value: emulation.RuntimeClass}}
+
+	if selector := runtimeClassNodeSelector(pod, class); selector != nil {
+		if pod.Spec.NodeSelector == nil {
+			patches = append(patches, patchOperation{Op: "add", Path: "/spec/nodeSelector", Value: selector})
+		} else {
+			keys := maps.Keys(selector)
+			slices.Sort(keys)
+			for _, key := range keys {
+				patches = append(patches, patchOperation{
+					Op:    "add",
+					Path:  "/spec/nodeSelector/" + pointerEscaper.Replace(key),
+					Value: selector[key],
+				})
+			}
+		}
+	}
+
+	// The API server rejects pods whose overhead differs from the one of
+	// their RuntimeClass.
+	if class != nil && class.Overhead != nil {
+		patches = append(patches, patchOperation{Op: "add", Path: "/spec/overhead", Value: class.Overhead.PodFixed})
+	}
+
+	return patches
+}
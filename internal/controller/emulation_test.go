@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	nodev1listers "k8s.io/client-go/listers/node/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func TestParseEmulation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Emulation
+		err      bool
+	}{
+		{
+			name:     "simple",
+			input:    "amd64=emulated-amd64:arm64",
+			expected: Emulation{Architecture: "amd64", RuntimeClass: "emulated-amd64", NodeArchitectures: []string{"arm64"}},
+		},
+		{
+			name:     "node arches",
+			input:    "riscv64=qemu:arm64, amd64",
+			expected: Emulation{Architecture: "riscv64", RuntimeClass: "qemu", NodeArchitectures: []string{"arm64", "amd64"}},
+		},
+		{
+			name:  "no runtime class",
+			input: "amd64=:arm64",
+			err:   true,
+		},
+		{
+			name:  "no node arches",
+			input: "amd64=emulated-amd64",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ParseEmulation(testCase.input)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse emulation: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestHandlePodEmulation(t *testing.T) {
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		return &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+	Emulations = []Emulation{{Architecture: "amd64", RuntimeClass: "emulated-amd64", NodeArchitectures: []string{"arm64"}}}
+	defer func() { Emulations = []Emulation{} }()
+
+	own := "gvisor"
+	testCases := []struct {
+		name         string
+		nodes        []string
+		runtimeClass *string
+		expected     string
+		emulated     bool
+	}{
+		{
+			name:     "emulated",
+			nodes:    []string{"arm64"},
+			expected: "arm64",
+			emulated: true,
+		},
+		{
+			name:     "native",
+			nodes:    []string{"amd64", "arm64"},
+			expected: "amd64",
+		},
+		{
+			name:         "own runtime class",
+			nodes:        []string{"arm64"},
+			runtimeClass: &own,
+			expected:     "amd64",
+		},
+		{
+			name:     "no emulation nodes",
+			nodes:    []string{"riscv64"},
+			expected: "amd64",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			useNodes(t, testCase.nodes...)
+
+			ctx := warnings.NewContext(context.Background())
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers:       []corev1.Container{{Name: "app", Image: "image"}},
+				RuntimeClassName: testCase.runtimeClass,
+			}}
+			got, err := handlePod(ctx, pod)
+			if err != nil {
+				t.Fatalf("Failed to handle pod: %v", err)
+			}
+
+			var patch []struct {
+				Path  string          `json:"path"`
+				Value json.RawMessage `json:"value"`
+			}
+			if err := json.Unmarshal([]byte(got), &patch); err != nil {
+				t.Fatalf("Failed to unmarshal the patch: %v", err)
+			}
+
+			affinity := corev1.Affinity{}
+			if err := json.Unmarshal(patch[0].Value, &affinity); err != nil {
+				t.Fatalf("Failed to unmarshal the affinity: %v", err)
+			}
+			arches := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values
+			if !reflect.DeepEqual(arches, []string{testCase.expected}) {
+				t.Errorf("got != want: %v != [%s]", arches, testCase.expected)
+			}
+
+			if testCase.emulated != (len(patch) == 2 && patch[1].Path == "/spec/runtimeClassName" && string(patch[1].Value) == `"emulated-amd64"`) {
+				t.Errorf("Expected the RuntimeClass to be set: %v, got: %s", testCase.emulated, got)
+			}
+			if testCase.emulated && len(warnings.FromContext(ctx)) != 1 {
+				t.Errorf("Expected a warning, got: %v", warnings.FromContext(ctx))
+			}
+		})
+	}
+}
+
+func TestHandlePodEmulationRuntimeClass(t *testing.T) {
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		return &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+	Emulations = []Emulation{{Architecture: "amd64", RuntimeClass: "emulated-amd64", NodeArchitectures: []string{"arm64"}}}
+	defer func() { Emulations = []Emulation{} }()
+	useNodes(t, "arm64")
+
+	toleration := corev1.Toleration{Key: "example.com/qemu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+	overhead := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "emulated-amd64"},
+		Handler:    "qemu",
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{"example.com/qemu": "true"},
+			Tolerations:  []corev1.Toleration{toleration},
+		},
+		Overhead: &nodev1.Overhead{PodFixed: overhead},
+	})
+	RuntimeClasses = nodev1listers.NewRuntimeClassLister(indexer)
+	defer func() { RuntimeClasses = nil }()
+
+	testCases := []struct {
+		name         string
+		runtimeClass string
+		nodeSelector map[string]string
+		expected     corev1.PodSpec
+	}{
+		{
+			name:         "merged",
+			runtimeClass: "emulated-amd64",
+			nodeSelector: map[string]string{"pool": "general"},
+			expected: corev1.PodSpec{
+				RuntimeClassName: &Emulations[0].RuntimeClass,
+				NodeSelector:     map[string]string{"pool": "general", "example.com/qemu": "true"},
+				Tolerations:      []corev1.Toleration{toleration},
+				Overhead:         overhead,
+			},
+		},
+		{
+			name:         "missing",
+			runtimeClass: "missing",
+		},
+		{
+			name:         "conflicting",
+			runtimeClass: "emulated-amd64",
+			nodeSelector: map[string]string{"example.com/qemu": "false"},
+			expected: corev1.PodSpec{
+				NodeSelector: map[string]string{"example.com/qemu": "false"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			Emulations[0].RuntimeClass = testCase.runtimeClass
+			defer func() { Emulations[0].RuntimeClass = "emulated-amd64" }()
+
+			ctx := warnings.NewContext(context.Background())
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers:   []corev1.Container{{Name: "app", Image: "image"}},
+				NodeSelector: testCase.nodeSelector,
+			}}
+			got, err := handlePod(ctx, pod)
+			if err != nil {
+				t.Fatalf("Failed to handle pod: %v", err)
+			}
+
+			patch, err := jsonpatch.DecodePatch([]byte(got))
+			if err != nil {
+				t.Fatalf("Failed to decode patch: %v", err)
+			}
+			podJSON, _ := json.Marshal(pod)
+			patchedJSON, err := patch.Apply(podJSON)
+			if err != nil {
+				t.Fatalf("Failed to apply patch: %v", err)
+			}
+			patched := corev1.Pod{}
+			if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+				t.Fatalf("Failed to unmarshal the patched pod: %v", err)
+			}
+
+			// The affinity is covered by TestHandlePodEmulation.
+			patched.Spec.Affinity = nil
+			patched.Spec.Containers = nil
+			if !reflect.DeepEqual(patched.Spec, testCase.expected) {
+				t.Errorf("got != want: %v != %v", patched.Spec, testCase.expected)
+			}
+		})
+	}
+}
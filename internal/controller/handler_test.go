@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/ongy/k8s-auto-arch/internal/resources"
 	"github.com/ongy/k8s-auto-arch/internal/resources/test"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -272,6 +273,11 @@ func TestHandleRequest(t *testing.T) {
 		},
 	}
 
+	// The test cases reuse image names with different architectures.
+	ttl := resources.DefaultCache.TTL
+	resources.DefaultCache.TTL = 0
+	defer func() { resources.DefaultCache.TTL = ttl }()
+
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			test.UseTestRegistry(testCase.arches)
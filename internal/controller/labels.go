@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ArchitectureLabel is a node label that tells the architecture of nodes.
+type ArchitectureLabel struct {
+	Key string
+	// Values translates OCI architectures to the values of the label, e.g.
+	// "arm" to "armhf" or "arm64" to instance families. Architectures that
+	// aren't translated are used as they are.
+	Values map[string][]string
+}
+
+// ArchitectureLabels are the labels the affinity selects architectures with.
+// Nodes match if any of the labels has one of the values.
+var ArchitectureLabels = []ArchitectureLabel{{Key: corev1.LabelArchStable}}
+
+// ParseArchitectureLabel parses a label key with optional translations, e.g.
+// "node.kubernetes.io/instance-family:arm64=c7g,arm64=m7g,amd64=m6i".
+func ParseArchitectureLabel(value string) (ArchitectureLabel, error) {
+	key, translations, _ := strings.Cut(value, ":")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ArchitectureLabel{}, fmt.Errorf("'%s' has no label key", value)
+	}
+
+	label := ArchitectureLabel{Key: key, Values: map[string][]string{}}
+	for _, part := range strings.Split(translations, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		arch, labelValue, ok := strings.Cut(part, "=")
+		arch = strings.TrimSpace(arch)
+		labelValue = strings.TrimSpace(labelValue)
+		if !ok || arch == "" || labelValue == "" {
+			return ArchitectureLabel{}, fmt.Errorf("'%s' isn't arch=value", part)
+		}
+		label.Values[arch] = append(label.Values[arch], labelValue)
+	}
+
+	return label, nil
+}
+
+// labelValues returns the values of the label for the arches.
+func (l ArchitectureLabel) labelValues(arches []string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, arch := range arches {
+		values, ok := l.Values[arch]
+		if !ok {
+			values = []string{arch}
+		}
+
+		for _, value := range values {
+			if !seen[value] {
+				seen[value] = true
+				ret = append(ret, value)
+			}
+		}
+	}
+
+	return ret
+}
+
+// architectureTerms returns a term per ArchitectureLabels that selects nodes
+// with the arches.
+func architectureTerms(arches []string) []corev1.NodeSelectorTerm {
+	terms := []corev1.NodeSelectorTerm{}
+	for _, label := range ArchitectureLabels {
+		terms = append(terms, corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{
+				{
+					Key:      label.Key,
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   label.labelValues(arches),
+				},
+			},
+		})
+	}
+
+	return terms
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseArchitectureLabel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected ArchitectureLabel
+		err      bool
+	}{
+		{
+			name:     "key",
+			input:    "beta.kubernetes.io/arch",
+			expected: ArchitectureLabel{Key: "beta.kubernetes.io/arch", Values: map[string][]string{}},
+		},
+		{
+			name:  "translations",
+			input: "node.kubernetes.io/instance-family:arm64=c7g, arm64=m7g,amd64=m6i",
+			expected: ArchitectureLabel{Key: "node.kubernetes.io/instance-family", Values: map[string][]string{
+				"arm64": {"c7g", "m7g"},
+				"amd64": {"m6i"},
+			}},
+		},
+		{
+			name:  "no key",
+			input: ":arm=armhf",
+			err:   true,
+		},
+		{
+			name:  "format",
+			input: "kubernetes.io/arch:armhf",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ParseArchitectureLabel(testCase.input)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse label: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestArchitectureTerms(t *testing.T) {
+	ArchitectureLabels = []ArchitectureLabel{
+		{Key: corev1.LabelArchStable, Values: map[string][]string{"arm": {"armhf"}}},
+		{Key: "node.kubernetes.io/instance-family", Values: map[string][]string{"arm64": {"c7g", "m7g"}, "arm": {"a1"}, "amd64": {"m6i"}}},
+	}
+	defer func() { ArchitectureLabels = []ArchitectureLabel{{Key: corev1.LabelArchStable}} }()
+
+	want := []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelArchStable, Operator: corev1.NodeSelectorOpIn, Values: []string{"arm64", "armhf"}}}},
+		{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "node.kubernetes.io/instance-family", Operator: corev1.NodeSelectorOpIn, Values: []string{"c7g", "m7g", "a1"}}}},
+	}
+	if got := architectureTerms([]string{"arm64", "arm"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
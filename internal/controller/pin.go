@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"go.opentelemetry.io/otel"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+// Records the container images as they were before they got pinned, as JSON
+// object from container name to image.
+const originalImagesAnnotation = "k8s-auto-arch.ongy.net/original-images"
+
+var (
+	// PinDigests rewrites container images to the digest that was inspected
+	// during admission, so tags pushed afterwards can't change what runs.
+	PinDigests = false
+
+	pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+)
+
+// pinnedReference returns the reference to the exact digest that was
+// inspected for image. It returns false if the reference already is pinned.
+func pinnedReference(image *resources.Image) (string, bool) {
+	if image == nil || image.Digest == "" {
+		return "", false
+	}
+
+	ref, err := regname.ParseReference(image.Reference)
+	if err != nil {
+		return "", false
+	}
+
+	tag, ok := ref.(regname.Tag)
+	if !ok {
+		return "", false
+	}
+
+	// Keep the repository as written, the tag may be implicit.
+	repository := strings.TrimSuffix(image.Reference, ":"+tag.TagStr())
+	return fmt.Sprintf("%s@%s", repository, image.Digest), true
+}
+
+// pinPatches pins the pod's images and records the original ones in
+// annotations.
+func pinPatches(ctx context.Context, pod *corev1.Pod, images map[string]*resources.Image, annotations map[string]string) ([]patchOperation, error) {
+	_, span := otel.Tracer("").Start(ctx, "pinPatches")
+	defer span.End()
+
+	patches := []patchOperation{}
+	original := map[string]string{}
+	pin := func(path, name, image string) {
+		pinned, ok := pinnedReference(images[image])
+		if !ok {
+			return
+		}
+
+		patches = append(patches, patchOperation{Op: "replace", Path: path, Value: pinned})
+		original[name] = image
+	}
+
+	for i, container := range pod.Spec.Containers {
+		pin(fmt.Sprintf("/spec/containers/%d/image", i), container.Name, container.Image)
+	}
+	for i, container := range pod.Spec.InitContainers {
+		pin(fmt.Sprintf("/spec/initContainers/%d/image", i), container.Name, container.Image)
+	}
+	for i, container := range pod.Spec.EphemeralContainers {
+		pin(fmt.Sprintf("/spec/ephemeralContainers/%d/image", i), container.Name, container.Image)
+	}
+
+	if len(original) == 0 {
+		return patches, nil
+	}
+
+	originalStr, err := json.Marshal(original)
+	if err != nil {
+		return nil, fmt.Errorf("marshal original images: %w", err)
+	}
+	annotations[originalImagesAnnotation] = string(originalStr)
+
+	return patches, nil
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+const testDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+func TestPinnedReference(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    *resources.Image
+		expected string
+		ok       bool
+	}{
+		{
+			name:  "nil",
+			input: nil,
+			ok:    false,
+		},
+		{
+			name:  "no-digest",
+			input: &resources.Image{Reference: "registry.local/org/image:latest"},
+			ok:    false,
+		},
+		{
+			name:     "tag",
+			input:    &resources.Image{Reference: "registry.local/org/image:1.2", Digest: testDigest},
+			expected: "registry.local/org/image@" + testDigest,
+			ok:       true,
+		},
+		{
+			name:     "implicit",
+			input:    &resources.Image{Reference: "nginx", Digest: testDigest},
+			expected: "nginx@" + testDigest,
+			ok:       true,
+		},
+		{
+			name:     "port",
+			input:    &resources.Image{Reference: "registry.local:5000/image", Digest: testDigest},
+			expected: "registry.local:5000/image@" + testDigest,
+			ok:       true,
+		},
+		{
+			name:  "pinned",
+			input: &resources.Image{Reference: "registry.local/org/image@" + testDigest, Digest: testDigest},
+			ok:    false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, ok := pinnedReference(testCase.input)
+			if ok != testCase.ok || got != testCase.expected {
+				t.Errorf("got != want: %s, %v != %s, %v", got, ok, testCase.expected, testCase.ok)
+			}
+		})
+	}
+}
+
+func TestPinPatches(t *testing.T) {
+	images := map[string]*resources.Image{
+		"image:1.2":   {Reference: "image:1.2", Digest: testDigest},
+		"init:latest": {Reference: "init:latest", Digest: testDigest},
+	}
+
+	testCases := []struct {
+		name     string
+		input    v1.Pod
+		expected v1.Pod
+	}{
+		{
+			name: "simple",
+			input: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers:     []v1.Container{{Name: "app", Image: "image:1.2"}},
+					InitContainers: []v1.Container{{Name: "init", Image: "init:latest"}},
+				},
+			},
+			expected: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						originalImagesAnnotation: `{"app":"image:1.2","init":"init:latest"}`,
+					},
+				},
+				Spec: v1.PodSpec{
+					Containers:     []v1.Container{{Name: "app", Image: "image@" + testDigest}},
+					InitContainers: []v1.Container{{Name: "init", Image: "init@" + testDigest}},
+				},
+			},
+		},
+		{
+			name: "annotated",
+			input: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"other": "value"},
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", Image: "image:1.2"}},
+				},
+			},
+			expected: v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"other":                  "value",
+						originalImagesAnnotation: `{"app":"image:1.2"}`,
+					},
+				},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", Image: "image@" + testDigest}},
+				},
+			},
+		},
+		{
+			name: "unresolved",
+			input: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", Image: "other@" + testDigest}},
+				},
+			},
+			expected: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "app", Image: "other@" + testDigest}},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			patches, err := pinPatches(context.Background(), &testCase.input, images, annotations)
+			if err != nil {
+				t.Fatalf("Failed to get pin patches: %v", err)
+			}
+			patches = append(patches, annotationPatches(&testCase.input, annotations)...)
+
+			patchStr, _ := json.Marshal(patches)
+			patch, err := jsonpatch.DecodePatch(patchStr)
+			if err != nil {
+				t.Fatalf("Failed to decode patch: %v", err)
+			}
+
+			podJSON, _ := json.Marshal(testCase.input)
+			patchedJSON, err := patch.Apply(podJSON)
+			if err != nil {
+				t.Fatalf("Failed to apply patch: %v", err)
+			}
+
+			var got, want v1.Pod
+			json.Unmarshal(patchedJSON, &got)
+			wantJSON, _ := json.Marshal(testCase.expected)
+			json.Unmarshal(wantJSON, &want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got != want:\n%v\n!=\n%v", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlePodPinAffinity(t *testing.T) {
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+		return &resources.Image{Reference: ref, Digest: testDigest, Architectures: map[string]bool{"amd64": true}}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+	PinDigests = true
+	defer func() { PinDigests = false }()
+
+	antiAffinity := &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+			Weight: 100,
+			PodAffinityTerm: v1.PodAffinityTerm{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+				TopologyKey:   v1.LabelHostname,
+			},
+		}},
+	}}
+	pod := v1.Pod{Spec: v1.PodSpec{
+		Containers: []v1.Container{{Name: "app", Image: "image:1.2"}},
+		Affinity:   antiAffinity,
+	}}
+
+	patchStr, err := handlePod(context.Background(), &pod)
+	if err != nil {
+		t.Fatalf("Failed to handle pod: %v", err)
+	}
+	patch, err := jsonpatch.DecodePatch([]byte(patchStr))
+	if err != nil {
+		t.Fatalf("Failed to decode patch: %v", err)
+	}
+	podJSON, _ := json.Marshal(pod)
+	patchedJSON, err := patch.Apply(podJSON)
+	if err != nil {
+		t.Fatalf("Failed to apply patch: %v", err)
+	}
+	var got v1.Pod
+	json.Unmarshal(patchedJSON, &got)
+
+	if got.Spec.Containers[0].Image != "image@"+testDigest {
+		t.Errorf("got != want: %s != image@%s", got.Spec.Containers[0].Image, testDigest)
+	}
+	if !reflect.DeepEqual(got.Spec.Affinity, antiAffinity) {
+		t.Errorf("Expected the affinity to be left alone, got: %v", got.Spec.Affinity)
+	}
+	if got.Annotations[originalImagesAnnotation] != `{"app":"image:1.2"}` {
+		t.Errorf("Expected the original images to be annotated, got: %v", got.Annotations)
+	}
+}
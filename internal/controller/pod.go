@@ -3,81 +3,380 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"strings"
 
 	"go.opentelemetry.io/otel"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/exp/slog"
 	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/ongy/k8s-auto-arch/internal/nodes"
 	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
 )
 
+// Records the overrides that decided the platforms of the pod's images, as
+// JSON object from container name to override pattern.
+const overridesAnnotation = "k8s-auto-arch.ongy.net/overrides"
+
 var (
 	// Indirection for testing
-	doArchitectures = resources.Architectures
-	doHandlePod     = handlePod
+	doHandlePod = handlePod
+)
+
+var (
+	// Resolver resolves the architectures of the pod's images.
+	Resolver resources.Resolver = resources.DefaultCache
+	// Nodes restricts the affinity to the architectures of the cluster's
+	// nodes, if set.
+	Nodes *nodes.Inventory
+	// DenyUnschedulable rejects pods whose images support none of the
+	// architectures of the cluster's nodes, or that no node can host with the
+	// injected affinity. Otherwise they are admitted with a warning. Pods that
+	// only autoscaled nodes could host are always admitted.
+	DenyUnschedulable = false
+	// DenyUnsigned rejects pods with images that aren't signed by any of the
+	// resources.SignatureKeys. Otherwise they are admitted without affinity.
+	DenyUnsigned = false
 )
 
-func podAffinity(ctx context.Context, pod *corev1.Pod) (*corev1.Affinity, error) {
-	ctx, span := otel.Tracer("").Start(ctx, "podAffinity")
+// deniedError rejects the admission request with its message.
+type deniedError struct {
+	message string
+}
+
+func (e *deniedError) Error() string {
+	return e.message
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// podAffinity requires the podArches, unless they are nil, and prefers
+// architectures with the preferred terms.
+func podAffinity(ctx context.Context, podArches []string, preferred []corev1.PreferredSchedulingTerm) *corev1.Affinity {
+	_, span := otel.Tracer("").Start(ctx, "podAffinity")
 	defer span.End()
 
-	podArches, err := doArchitectures(ctx, pod)
-	if err != nil {
-		return nil, fmt.Errorf("get pod architectures: %w", err)
-	}
-
-	return &corev1.Affinity{
-		NodeAffinity: &corev1.NodeAffinity{
-			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
-				NodeSelectorTerms: []corev1.NodeSelectorTerm{
-					{
-						MatchExpressions: []corev1.NodeSelectorRequirement{
-							{
-								Key:      "kubernetes.io/arch",
-								Operator: "In",
-								Values:   podArches,
-							},
-						},
-					},
-				},
-			},
-		},
-	}, nil
+	if len(podArches) == 0 && len(preferred) == 0 {
+		// None of the images cares about the architecture.
+		return nil
+	}
+
+	affinity := &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: preferred}
+	if len(podArches) > 0 {
+		affinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: compatibleTerms(podArches),
+		}
+	}
+
+	return &corev1.Affinity{NodeAffinity: affinity}
+}
+
+// available returns whether nodes of the clusterArches run the arch, natively
+// or through Compatibilities.
+func available(clusterArches map[string]bool, arch string) bool {
+	if clusterArches[arch] {
+		return true
+	}
+	for _, nodeArch := range compatibleArches([]string{arch}, true) {
+		if clusterArches[nodeArch] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restrictToNodes intersects the pod's architectures with the ones of the
+// cluster's nodes, including nodes that run them through Compatibilities. It
+// returns nil if the pod runs on all of them, so portable pods aren't patched.
+func restrictToNodes(ctx context.Context, podArches []string) ([]string, error) {
+	_, span := otel.Tracer("").Start(ctx, "restrictToNodes")
+	defer span.End()
+
+	if Nodes == nil {
+		return podArches, nil
+	}
+	clusterArches := Nodes.Architectures()
+	if clusterArches == nil {
+		return podArches, nil
+	}
+
+	ret := []string{}
+	for _, arch := range podArches {
+		if available(clusterArches, arch) {
+			ret = append(ret, arch)
+		}
+	}
+
+	if len(ret) == 0 {
+		available := maps.Keys(clusterArches)
+		slices.Sort(available)
+		message := fmt.Sprintf("the pod's images support %v, but the cluster only has or provisions nodes for %v", podArches, available)
+		if DenyUnschedulable {
+			return nil, &deniedError{message: message}
+		}
+
+		warnings.Add(ctx, "%s", message)
+		return podArches, nil
+	}
+	runnable := append(slices.Clone(ret), compatibleArches(ret, false)...)
+	for arch := range clusterArches {
+		if !slices.Contains(runnable, arch) {
+			return ret, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// describeArchitectures lists the architectures of the images that constrain
+// them, sorted by reference.
+func describeArchitectures(images map[string]*resources.Image) string {
+	refs := maps.Keys(images)
+	slices.Sort(refs)
+
+	described := []string{}
+	for _, ref := range refs {
+		if images[ref].Architectures == nil {
+			continue
+		}
+		arches := maps.Keys(images[ref].Architectures)
+		slices.Sort(arches)
+		described = append(described, fmt.Sprintf("%s supports %v", ref, arches))
+	}
+
+	return strings.Join(described, ", ")
+}
+
+// preflight checks that a node can host the pod with the affinity, the added
+// tolerations and the emulation with its RuntimeClass, if any.
+func preflight(ctx context.Context, pod *corev1.Pod, affinity *corev1.Affinity, added []corev1.Toleration, emulated *Emulation, class *nodev1.RuntimeClass) error {
+	if Nodes == nil {
+		return nil
+	}
+
+	final := pod.DeepCopy()
+	final.Spec.Affinity = affinity
+	if emulated != nil {
+		final.Spec.RuntimeClassName = &emulated.RuntimeClass
+		if selector := runtimeClassNodeSelector(pod, class); selector != nil {
+			if final.Spec.NodeSelector == nil {
+				final.Spec.NodeSelector = map[string]string{}
+			}
+			for key, value := range selector {
+				final.Spec.NodeSelector[key] = value
+			}
+		}
+	}
+	final.Spec.Tolerations = append(final.Spec.Tolerations, added...)
+	err := Nodes.Preflight(ctx, final)
+	if err == nil {
+		return nil
+	}
+
+	if DenyUnschedulable && !Nodes.Autoscaled() {
+		return &deniedError{message: err.Error()}
+	}
+
+	warnings.Add(ctx, "%v", err)
+	return nil
+}
+
+// overrides returns the override patterns that matched the pod's images by
+// container name.
+func overrides(pod *corev1.Pod, images map[string]*resources.Image) map[string]string {
+	ret := map[string]string{}
+	add := func(name, ref string) {
+		if image := images[ref]; image != nil && image.Override != "" {
+			ret[name] = image.Override
+		}
+	}
+
+	for _, container := range pod.Spec.Containers {
+		add(container.Name, container.Image)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		add(container.Name, container.Image)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		add(container.Name, container.Image)
+	}
+
+	return ret
+}
+
+// annotationPatches adds the annotations to the pod.
+func annotationPatches(pod *corev1.Pod, annotations map[string]string) []patchOperation {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	if pod.Annotations == nil {
+		return []patchOperation{{Op: "add", Path: "/metadata/annotations", Value: annotations}}
+	}
+
+	keys := maps.Keys(annotations)
+	slices.Sort(keys)
+	patches := []patchOperation{}
+	for _, key := range keys {
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + pointerEscaper.Replace(key),
+			Value: annotations[key],
+		})
+	}
+
+	return patches
 }
 
 func handlePod(ctx context.Context, pod *corev1.Pod) (string, error) {
 	ctx, span := otel.Tracer("").Start(ctx, "handlePod")
 	defer span.End()
 
-	if pod.Spec.Affinity == nil {
-		affinity, err := podAffinity(ctx, pod)
-		if err != nil {
-			return "", fmt.Errorf("get pod affinity: %w", err)
+	podArches, images, err := resources.Architectures(ctx, Resolver, pod)
+	if errors.Is(err, resources.ErrUnsigned) {
+		if DenyUnsigned {
+			return "", &deniedError{message: err.Error()}
+		}
+
+		warnings.Add(ctx, "not injecting node affinity: %v", err)
+		return "", nil
+	}
+	if err != nil && pod.Spec.Affinity != nil {
+		// Pods with their own affinity only miss the pinning, don't fail them.
+		warnings.Add(ctx, "not pinning images: %v", err)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get pod architectures: %w", err)
+	}
+
+	// An existing affinity is left alone, the images are still pinned and
+	// annotated.
+	if pod.Spec.Affinity != nil {
+		podArches = nil
+	}
+	if podArches != nil && len(podArches) == 0 {
+		message := fmt.Sprintf("the pod's images have no architecture in common: %s", describeArchitectures(images))
+		if DenyUnschedulable {
+			return "", &deniedError{message: message}
+		}
+
+		// The API server rejects a node affinity without architectures.
+		warnings.Add(ctx, "%s, not constraining the pod", message)
+		podArches = nil
+	}
+
+	var preferred []corev1.PreferredSchedulingTerm
+	var added []corev1.Toleration
+	var emulated *Emulation
+	var class *nodev1.RuntimeClass
+	if podArches != nil {
+		supported := podArches
+		if emulated = emulation(ctx, pod, podArches); emulated != nil {
+			if class, err = runtimeClass(ctx, pod, emulated); err != nil {
+				warnings.Add(ctx, "not running the pod emulated: %v", err)
+				emulated = nil
+			}
+		}
+		if emulated != nil {
+			warnings.Add(ctx, "no nodes run %v, running the pod emulated with RuntimeClass %s on %v nodes instead. It will be considerably slower", podArches, emulated.RuntimeClass, emulated.NodeArchitectures)
+			podArches = emulated.NodeArchitectures
+		} else {
+			podArches, err = restrictToNodes(ctx, podArches)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// Pods that run on all nodes are still steered to the preferred ones.
+		candidates := podArches
+		if candidates == nil {
+			candidates = supported
+		}
+		preferred = preferredTerms(architectureWeights(ctx, pod), candidates)
+		added = tolerations(ctx, pod, append(slices.Clone(candidates), compatibleArches(candidates, true)...))
+		added = append(added, runtimeClassTolerations(pod, added, class)...)
+	}
+
+	// Pods that aren't constrained still get their images pinned and
+	// annotated.
+	affinity := podAffinity(ctx, podArches, preferred)
+	if affinity != nil || added != nil {
+		if err := preflight(ctx, pod, affinity, added, emulated, class); err != nil {
+			return "", err
+		}
+
+		if SuggestTags {
+			suggestTags(ctx, images)
 		}
+	}
 
-		affinityStr, err := json.Marshal(map[string]interface{}{"op": "add", "path": "/spec/affinity", "value": affinity})
+	patch := []patchOperation{}
+	if affinity != nil {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/affinity", Value: affinity})
+	}
+	patch = append(patch, tolerationPatches(pod, added)...)
+	if emulated != nil {
+		patch = append(patch, runtimeClassPatches(pod, emulated, class)...)
+	}
+	annotations := map[string]string{}
+	if PinDigests {
+		pins, err := pinPatches(ctx, pod, images, annotations)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to marshal affinity: %v\n", err)
+			return "", fmt.Errorf("pin images: %w", err)
 		}
-		return fmt.Sprintf(`[%s]`, affinityStr), nil
+		patch = append(patch, pins...)
+	}
+	if overridden := overrides(pod, images); len(overridden) > 0 {
+		overriddenStr, err := json.Marshal(overridden)
+		if err != nil {
+			return "", fmt.Errorf("marshal overrides: %w", err)
+		}
+		annotations[overridesAnnotation] = string(overriddenStr)
+	}
+	patch = append(patch, annotationPatches(pod, annotations)...)
+	if len(patch) == 0 {
+		return "", nil
 	}
 
-	return "", nil
+	patchStr, err := json.Marshal(patch)
+	if err != nil {
+		return "", fmt.Errorf("marshal patch: %w", err)
+	}
+	return string(patchStr), nil
 }
 
 func ReviewPod(ctx context.Context, request *v1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
 	ctx, span := otel.Tracer("").Start(ctx, "ReviewPod")
 	defer span.End()
+	ctx = warnings.NewContext(ctx)
 
 	rawRequest := request.Object.Raw
 	pod := corev1.Pod{}
 	if err := json.Unmarshal(rawRequest, &pod); err != nil {
 		return nil, fmt.Errorf("decode raw pod: %w", err)
 	}
+	if pod.Namespace == "" {
+		// Pods created by controllers only get their namespace from the request.
+		pod.Namespace = request.Namespace
+	}
+	if request.DryRun != nil && *request.DryRun {
+		ctx = resources.WithDryRun(ctx)
+	}
 
 	// Create a response that will add a label to the pod if it does
 	// not already have a label with the key of "hello". In this case
@@ -86,11 +385,25 @@ func ReviewPod(ctx context.Context, request *v1.AdmissionRequest) (*admissionv1.
 	patchType := v1.PatchTypeJSONPatch
 
 	patch, err := doHandlePod(ctx, &pod)
+	var denied *deniedError
+	if errors.As(err, &denied) {
+		slog.InfoContext(ctx, "Denying pod", "reason", denied.message)
+		admissionResponse.Allowed = false
+		admissionResponse.Warnings = warnings.FromContext(ctx)
+		admissionResponse.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: denied.message,
+		}
+		return admissionResponse, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("get pod patch: %w", err)
 	}
 
 	admissionResponse.Allowed = true
+	admissionResponse.Warnings = warnings.FromContext(ctx)
 	if patch != "" {
 		admissionResponse.PatchType = &patchType
 		admissionResponse.Patch = []byte(patch)
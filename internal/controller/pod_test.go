@@ -3,33 +3,40 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/ongy/k8s-auto-arch/internal/nodes"
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestPodAffinity(t *testing.T) {
+	preferArm := []corev1.PreferredSchedulingTerm{{
+		Weight: 50,
+		Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "kubernetes.io/arch", Operator: "In", Values: []string{"arm64"}}},
+		},
+	}}
+
 	testCases := []struct {
-		name     string
-		arches   []string
-		input    v1.Pod
-		expected v1.Affinity
+		name      string
+		arches    []string
+		preferred []corev1.PreferredSchedulingTerm
+		expected  v1.Affinity
 	}{
 		{
 			name:   "simple",
 			arches: []string{"amd64"},
-			input: v1.Pod{
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Image: "doesn't matter",
-						},
-					},
-				},
-			},
 			expected: corev1.Affinity{
 				NodeAffinity: &corev1.NodeAffinity{
 					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
@@ -51,15 +58,6 @@ func TestPodAffinity(t *testing.T) {
 		{
 			name:   "multi",
 			arches: []string{"amd64", "arm64"},
-			input: v1.Pod{
-				Spec: v1.PodSpec{
-					Containers: []v1.Container{
-						{
-							Image: "doesn't matter",
-						},
-					},
-				},
-			},
 			expected: corev1.Affinity{
 				NodeAffinity: &corev1.NodeAffinity{
 					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
@@ -78,19 +76,31 @@ func TestPodAffinity(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:      "preferred",
+			arches:    nil,
+			preferred: preferArm,
+			expected: corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: preferArm,
+				},
+			},
+		},
+		{
+			name:      "empty",
+			arches:    []string{},
+			preferred: preferArm,
+			expected: corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: preferArm,
+				},
+			},
+		},
 	}
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			doArchitectures = func(context.Context, *v1.Pod) ([]string, error) {
-				return testCase.arches, nil
-			}
-
-			got, err := podAffinity(context.Background(), &testCase.input)
-			if err != nil {
-				t.Fatalf("Get pod affinity: %v", err)
-			}
-
+			got := podAffinity(context.Background(), testCase.arches, testCase.preferred)
 			if !reflect.DeepEqual(got, &testCase.expected) {
 				t.Errorf("got != wanted: %v != %v", got, &testCase.expected)
 			}
@@ -150,13 +160,35 @@ func TestHandlePod(t *testing.T) {
 			},
 			expected: nil,
 		},
+		{
+			name:   "unconstrained",
+			arches: nil,
+			input: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: "doesn't matter",
+						},
+					},
+				},
+			},
+			expected: nil,
+		},
 	}
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			doArchitectures = func(context.Context, *v1.Pod) ([]string, error) {
-				return testCase.arches, nil
-			}
+			Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+				image := &resources.Image{Reference: ref}
+				if testCase.arches != nil {
+					image.Architectures = map[string]bool{}
+					for _, arch := range testCase.arches {
+						image.Architectures[arch] = true
+					}
+				}
+				return image, nil
+			})
+			defer func() { Resolver = resources.DefaultCache }()
 
 			got, err := handlePod(context.Background(), &testCase.input)
 			if err != nil {
@@ -199,11 +231,369 @@ func TestHandlePod(t *testing.T) {
 	}
 }
 
+// useNodes restricts pods to a cluster with nodes of the given architectures
+// for the duration of the test.
+func useNodes(t *testing.T, arches ...string) {
+	client := fake.NewSimpleClientset()
+	for i, arch := range arches {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i), Labels: map[string]string{v1.LabelArchStable: arch}}}
+		if _, err := client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Failed to create node: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	Nodes = nodes.NewInventory(client)
+	go Nodes.Run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		Nodes = nil
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for Nodes.Architectures() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Node inventory didn't sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestrictToNodes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		nodes    []string
+		input    []string
+		compat   []Compatibility
+		deny     bool
+		expected []string
+		denied   bool
+		warnings int
+	}{
+		{
+			name:     "no-inventory",
+			input:    []string{"amd64", "riscv64"},
+			expected: []string{"amd64", "riscv64"},
+		},
+		{
+			name:     "subset",
+			nodes:    []string{"amd64", "arm64"},
+			input:    []string{"amd64", "riscv64"},
+			expected: []string{"amd64"},
+		},
+		{
+			name:     "portable",
+			nodes:    []string{"amd64", "arm64"},
+			input:    []string{"amd64", "arm64", "riscv64"},
+			expected: nil,
+		},
+		{
+			name:     "unschedulable",
+			nodes:    []string{"amd64"},
+			input:    []string{"riscv64"},
+			expected: []string{"riscv64"},
+			warnings: 1,
+		},
+		{
+			name:   "deny",
+			nodes:  []string{"amd64"},
+			input:  []string{"riscv64"},
+			deny:   true,
+			denied: true,
+		},
+		{
+			name:     "compatible",
+			nodes:    []string{"arm64"},
+			input:    []string{"arm"},
+			compat:   []Compatibility{{NodeArch: "arm64", Architectures: []string{"arm"}}},
+			expected: nil,
+		},
+		{
+			name:     "gated",
+			nodes:    []string{"arm64"},
+			input:    []string{"arm"},
+			compat:   []Compatibility{{NodeArch: "arm64", Architectures: []string{"arm"}, Label: "example.com/compat32"}},
+			expected: []string{"arm"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if testCase.nodes != nil {
+				useNodes(t, testCase.nodes...)
+			}
+			Compatibilities = testCase.compat
+			defer func() { Compatibilities = []Compatibility{} }()
+			DenyUnschedulable = testCase.deny
+			defer func() { DenyUnschedulable = false }()
+
+			ctx := warnings.NewContext(context.Background())
+			got, err := restrictToNodes(ctx, testCase.input)
+
+			var denied *deniedError
+			if errors.As(err, &denied) != testCase.denied {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if testCase.denied {
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to restrict architectures: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+			if len(warnings.FromContext(ctx)) != testCase.warnings {
+				t.Errorf("got != want: %v != %d warnings", warnings.FromContext(ctx), testCase.warnings)
+			}
+		})
+	}
+}
+
+func TestHandlePodPreflight(t *testing.T) {
+	testCases := []struct {
+		name   string
+		deny   bool
+		denied bool
+	}{
+		{
+			name:   "warn",
+			deny:   false,
+			denied: false,
+		},
+		{
+			name:   "deny",
+			deny:   true,
+			denied: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			useNodes(t, "amd64", "arm64")
+			Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+				return &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}, nil
+			})
+			defer func() { Resolver = resources.DefaultCache }()
+			DenyUnschedulable = testCase.deny
+			defer func() { DenyUnschedulable = false }()
+
+			ctx := warnings.NewContext(context.Background())
+			pod := &v1.Pod{Spec: v1.PodSpec{
+				NodeSelector: map[string]string{"pool": "gpu-workers"},
+				Containers:   []v1.Container{{Name: "app", Image: "image"}},
+			}}
+			got, err := handlePod(ctx, pod)
+
+			var denied *deniedError
+			if errors.As(err, &denied) != testCase.denied {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !testCase.denied {
+				if err != nil {
+					t.Fatalf("Failed to handle pod: %v", err)
+				}
+				if got == "" {
+					t.Errorf("Expected the affinity to be patched anyway")
+				}
+				if len(warnings.FromContext(ctx)) != 1 {
+					t.Errorf("Expected a warning, got: %v", warnings.FromContext(ctx))
+				}
+			}
+		})
+	}
+}
+
+func TestHandlePodNoCommonArchitecture(t *testing.T) {
+	testCases := []struct {
+		name   string
+		deny   bool
+		denied bool
+	}{
+		{
+			name:   "warn",
+			deny:   false,
+			denied: false,
+		},
+		{
+			name:   "deny",
+			deny:   true,
+			denied: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+				return &resources.Image{Reference: ref, Architectures: map[string]bool{ref: true}}, nil
+			})
+			defer func() { Resolver = resources.DefaultCache }()
+			DenyUnschedulable = testCase.deny
+			defer func() { DenyUnschedulable = false }()
+
+			ctx := warnings.NewContext(context.Background())
+			pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{
+				{Name: "app", Image: "amd64"},
+				{Name: "sidecar", Image: "arm64"},
+			}}}
+			got, err := handlePod(ctx, pod)
+
+			var denied *deniedError
+			if errors.As(err, &denied) != testCase.denied {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if testCase.denied {
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to handle pod: %v", err)
+			}
+			if got != "" {
+				t.Errorf("Expected no patch, got: %s", got)
+			}
+			want := []string{"the pod's images have no architecture in common: amd64 supports [amd64], arm64 supports [arm64], not constraining the pod"}
+			if !reflect.DeepEqual(warnings.FromContext(ctx), want) {
+				t.Errorf("got != want: %v != %v", warnings.FromContext(ctx), want)
+			}
+		})
+	}
+}
+
+func TestHandlePodOverride(t *testing.T) {
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+		image := &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}
+		if ref == "registry.local/broken:1.0" {
+			image.Source = resources.SourceOverride
+			image.Override = "registry.local/broken:*"
+		}
+		return image, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{
+		{Name: "app", Image: "registry.local/app:1.0"},
+		{Name: "broken", Image: "registry.local/broken:1.0"},
+	}}}
+	got, err := handlePod(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("Failed to handle pod: %v", err)
+	}
+
+	var patch []patchOperation
+	if err := json.Unmarshal([]byte(got), &patch); err != nil {
+		t.Fatalf("Failed to unmarshal the patch: %v", err)
+	}
+	if len(patch) != 2 || patch[1].Path != "/metadata/annotations" {
+		t.Fatalf("Expected affinity and annotations, got: %s", got)
+	}
+
+	want := map[string]any{overridesAnnotation: `{"broken":"registry.local/broken:*"}`}
+	if !reflect.DeepEqual(patch[1].Value, want) {
+		t.Errorf("got != want: %v != %v", patch[1].Value, want)
+	}
+}
+
+func TestHandlePodPortable(t *testing.T) {
+	useNodes(t, "amd64", "arm64")
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *v1.Pod) (*resources.Image, error) {
+		return &resources.Image{
+			Reference:     ref,
+			Digest:        testDigest,
+			Architectures: map[string]bool{"amd64": true, "arm64": true},
+			Source:        resources.SourceOverride,
+			Override:      "image:*",
+		}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+	PinDigests = true
+	defer func() { PinDigests = false }()
+
+	pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app", Image: "image:1.2"}}}}
+	got, err := handlePod(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("Failed to handle pod: %v", err)
+	}
+
+	var patch []patchOperation
+	if err := json.Unmarshal([]byte(got), &patch); err != nil {
+		t.Fatalf("Failed to unmarshal the patch: %v", err)
+	}
+	paths := []string{}
+	for _, operation := range patch {
+		paths = append(paths, operation.Path)
+	}
+	want := []string{"/spec/containers/0/image", "/metadata/annotations"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got != want: %v != %v", paths, want)
+	}
+
+	annotations := map[string]any{
+		originalImagesAnnotation: `{"app":"image:1.2"}`,
+		overridesAnnotation:      `{"app":"image:*"}`,
+	}
+	if !reflect.DeepEqual(patch[1].Value, annotations) {
+		t.Errorf("got != want: %v != %v", patch[1].Value, annotations)
+	}
+}
+
+func TestHandlePodUnsigned(t *testing.T) {
+	testCases := []struct {
+		name   string
+		deny   bool
+		denied bool
+	}{
+		{
+			name:   "ignore",
+			deny:   false,
+			denied: false,
+		},
+		{
+			name:   "deny",
+			deny:   true,
+			denied: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			Resolver = resources.ResolverFunc(func(context.Context, string, *v1.Pod) (*resources.Image, error) {
+				return nil, fmt.Errorf("verify signature: %w", resources.ErrUnsigned)
+			})
+			defer func() { Resolver = resources.DefaultCache }()
+			DenyUnsigned = testCase.deny
+			defer func() { DenyUnsigned = false }()
+
+			ctx := warnings.NewContext(context.Background())
+			pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app", Image: "image"}}}}
+			got, err := handlePod(ctx, pod)
+
+			var denied *deniedError
+			if errors.As(err, &denied) != testCase.denied {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !testCase.denied {
+				if err != nil {
+					t.Fatalf("Failed to handle pod: %v", err)
+				}
+				if got != "" {
+					t.Errorf("Expected no patch, got: %s", got)
+				}
+				if len(warnings.FromContext(ctx)) != 1 {
+					t.Errorf("Expected a warning, got: %v", warnings.FromContext(ctx))
+				}
+			}
+		})
+	}
+}
+
 func TestReviewPod(t *testing.T) {
 	patchType := admissionv1.PatchTypeJSONPatch
 	testCases := []struct {
 		name     string
 		patch    string
+		err      error
+		warnings []string
 		expected *admissionv1.AdmissionResponse
 	}{
 		{
@@ -222,11 +612,38 @@ func TestReviewPod(t *testing.T) {
 				Allowed:   true,
 			},
 		},
+		{
+			name:     "warnings",
+			patch:    "",
+			warnings: []string{"warning"},
+			expected: &admissionv1.AdmissionResponse{
+				Allowed:  true,
+				Warnings: []string{"warning"},
+			},
+		},
+		{
+			name: "denied",
+			err:  fmt.Errorf("wrapped: %w", &deniedError{message: "denied"}),
+			expected: &admissionv1.AdmissionResponse{
+				Allowed: false,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    http.StatusForbidden,
+					Reason:  metav1.StatusReasonForbidden,
+					Message: "denied",
+				},
+			},
+		},
 	}
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			doHandlePod = func(context.Context, *v1.Pod) (string, error) { return testCase.patch, nil }
+			doHandlePod = func(ctx context.Context, _ *v1.Pod) (string, error) {
+				for _, warning := range testCase.warnings {
+					warnings.Add(ctx, "%s", warning)
+				}
+				return testCase.patch, testCase.err
+			}
 			request := admissionv1.AdmissionRequest{}
 
 			request.Object.Raw = []byte("{}")
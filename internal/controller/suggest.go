@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// Suggestions are best effort, they must not delay the admission too much.
+const suggestTimeout = 2 * time.Second
+
+var (
+	// SuggestTags adds warnings listing tags of the images that limit the
+	// architectures of a pod, which would support the missing architectures.
+	SuggestTags = false
+	// SuggestArchitectures are the architectures tags are suggested for. If
+	// empty, the architectures supported by the other images of the pod are
+	// used.
+	SuggestArchitectures = []string{}
+	// SuggestResolver resolves candidate tags. It bypasses DefaultCache, so
+	// tags no pod uses don't end up in the cache, its snapshot or the shared
+	// ImageArchitectures.
+	SuggestResolver resources.Resolver = resources.Registry{}
+
+	// Indirection for testing
+	doSuggestTags = resources.SuggestTags
+)
+
+// missingArchitectures returns the wanted architectures the image doesn't
+// support, sorted.
+func missingArchitectures(image *resources.Image, images map[string]*resources.Image) []string {
+	var wanted map[string]bool
+	if len(SuggestArchitectures) > 0 {
+		wanted = map[string]bool{}
+		for _, arch := range SuggestArchitectures {
+			wanted[arch] = true
+		}
+	} else {
+		for _, other := range images {
+			if other.Reference != image.Reference && other.Architectures != nil {
+				wanted = util.Intersect(wanted, other.Architectures)
+			}
+		}
+	}
+
+	missing := []string{}
+	for arch := range wanted {
+		if !image.Architectures[arch] {
+			missing = append(missing, arch)
+		}
+	}
+	slices.Sort(missing)
+
+	return missing
+}
+
+// suggestTags warns about the images that limit the architectures of the pod,
+// and suggests tags that would support the missing architectures.
+func suggestTags(ctx context.Context, images map[string]*resources.Image) {
+	ctx, span := otel.Tracer("").Start(ctx, "suggestTags")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+
+	refs := util.Keys(images)
+	slices.Sort(refs)
+	for _, ref := range refs {
+		image := images[ref]
+		if image.Architectures == nil {
+			continue
+		}
+
+		missing := missingArchitectures(image, images)
+		if len(missing) == 0 {
+			continue
+		}
+
+		suggestions, err := doSuggestTags(ctx, SuggestResolver, ref, missing)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to suggest tags", "container", ref, "err", err)
+			continue
+		}
+
+		if len(suggestions) == 0 {
+			warnings.Add(ctx, "image %s doesn't support %s", ref, strings.Join(missing, ", "))
+			continue
+		}
+
+		tags := []string{}
+		for _, suggestion := range suggestions {
+			tags = append(tags, suggestion.Reference)
+		}
+		warnings.Add(ctx, "image %s doesn't support %s, but these tags do: %s", ref, strings.Join(missing, ", "), strings.Join(tags, ", "))
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func TestSuggestTags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		wanted   []string
+		images   map[string]*resources.Image
+		expected []string
+	}{
+		{
+			name:   "limiting",
+			wanted: []string{},
+			images: map[string]*resources.Image{
+				"image:1.0": {Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}},
+				"other":     {Reference: "other", Architectures: map[string]bool{"amd64": true, "arm64": true}},
+			},
+			expected: []string{"image image:1.0 doesn't support arm64, but these tags do: image:1.1"},
+		},
+		{
+			name:   "single",
+			wanted: []string{},
+			images: map[string]*resources.Image{
+				"image:1.0": {Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}},
+			},
+			expected: nil,
+		},
+		{
+			name:   "unconstrained",
+			wanted: []string{},
+			images: map[string]*resources.Image{
+				"image:1.0": {Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}},
+				"other":     {Reference: "other"},
+			},
+			expected: nil,
+		},
+		{
+			name:   "configured",
+			wanted: []string{"arm64", "riscv64"},
+			images: map[string]*resources.Image{
+				"image:1.0": {Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}},
+				"other":     {Reference: "other", Architectures: map[string]bool{"amd64": true, "arm64": true, "riscv64": true}},
+			},
+			expected: []string{"image image:1.0 doesn't support arm64, riscv64"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			SuggestArchitectures = testCase.wanted
+			defer func() { SuggestArchitectures = []string{} }()
+
+			doSuggestTags = func(_ context.Context, _ resources.Resolver, ref string, missing []string) ([]*resources.Image, error) {
+				if ref == "image:1.0" && slices.Equal(missing, []string{"arm64"}) {
+					return []*resources.Image{{Reference: "image:1.1"}}, nil
+				}
+
+				return []*resources.Image{}, nil
+			}
+
+			ctx := warnings.NewContext(context.Background())
+			suggestTags(ctx, testCase.images)
+
+			got := warnings.FromContext(ctx)
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
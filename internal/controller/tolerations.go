@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ArchitectureTolerations are added to pods that can run on the architecture,
+// so they may use node pools tainted to keep other pods off them.
+var ArchitectureTolerations = map[string][]corev1.Toleration{}
+
+// ParseToleration parses an architecture and the toleration for it, e.g.
+// "arm64=arch=arm64:NoSchedule". The toleration is in the format of taints
+// (key[=value][:effect]). Without a value it tolerates any value, without an
+// effect any effect.
+func ParseToleration(value string) (string, corev1.Toleration, error) {
+	arch, spec, ok := strings.Cut(value, "=")
+	if !ok || arch == "" || spec == "" {
+		return "", corev1.Toleration{}, fmt.Errorf("'%s' isn't arch=key[=value][:effect]", value)
+	}
+
+	toleration := corev1.Toleration{Operator: corev1.TolerationOpExists}
+	spec, effect, hasEffect := strings.Cut(spec, ":")
+	if hasEffect {
+		switch corev1.TaintEffect(effect) {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			toleration.Effect = corev1.TaintEffect(effect)
+		default:
+			return "", corev1.Toleration{}, fmt.Errorf("unknown taint effect '%s'", effect)
+		}
+	}
+
+	key, tolerated, hasValue := strings.Cut(spec, "=")
+	if key == "" {
+		return "", corev1.Toleration{}, fmt.Errorf("'%s' has no key", value)
+	}
+	toleration.Key = key
+	if hasValue {
+		toleration.Operator = corev1.TolerationOpEqual
+		toleration.Value = tolerated
+	}
+
+	return arch, toleration, nil
+}
+
+// tolerations returns the ArchitectureTolerations of the arches the pod
+// doesn't have yet.
+func tolerations(ctx context.Context, pod *corev1.Pod, arches []string) []corev1.Toleration {
+	_, span := otel.Tracer("").Start(ctx, "tolerations", trace.WithAttributes(attribute.StringSlice("arches", arches)))
+	defer span.End()
+
+	sorted := slices.Clone(arches)
+	slices.Sort(sorted)
+
+	present := slices.Clone(pod.Spec.Tolerations)
+	ret := []corev1.Toleration{}
+	for _, arch := range sorted {
+		for _, toleration := range ArchitectureTolerations[arch] {
+			toleration := toleration
+			if slices.ContainsFunc(present, func(other corev1.Toleration) bool { return toleration.MatchToleration(&other) }) {
+				continue
+			}
+
+			present = append(present, toleration)
+			ret = append(ret, toleration)
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+
+	return ret
+}
+
+// tolerationPatches adds the tolerations to the pod.
+func tolerationPatches(pod *corev1.Pod, tolerations []corev1.Toleration) []patchOperation {
+	if len(tolerations) == 0 {
+		return nil
+	}
+
+	if pod.Spec.Tolerations == nil {
+		return []patchOperation{{Op: "add", Path: "/spec/tolerations", Value: tolerations}}
+	}
+
+	patches := []patchOperation{}
+	for _, toleration := range tolerations {
+		patches = append(patches, patchOperation{Op: "add", Path: "/spec/tolerations/-", Value: toleration})
+	}
+
+	return patches
+}
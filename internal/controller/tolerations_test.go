@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+func TestParseToleration(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		arch     string
+		expected corev1.Toleration
+		err      bool
+	}{
+		{
+			name:     "full",
+			input:    "arm64=arch=arm64:NoSchedule",
+			arch:     "arm64",
+			expected: corev1.Toleration{Key: "arch", Operator: corev1.TolerationOpEqual, Value: "arm64", Effect: corev1.TaintEffectNoSchedule},
+		},
+		{
+			name:     "key",
+			input:    "arm64=graviton",
+			arch:     "arm64",
+			expected: corev1.Toleration{Key: "graviton", Operator: corev1.TolerationOpExists},
+		},
+		{
+			name:     "empty value",
+			input:    "riscv64=experimental=:NoExecute",
+			arch:     "riscv64",
+			expected: corev1.Toleration{Key: "experimental", Operator: corev1.TolerationOpEqual, Effect: corev1.TaintEffectNoExecute},
+		},
+		{
+			name:  "no toleration",
+			input: "arm64",
+			err:   true,
+		},
+		{
+			name:  "no key",
+			input: "arm64=:NoSchedule",
+			err:   true,
+		},
+		{
+			name:  "effect",
+			input: "arm64=arch=arm64:Never",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			arch, got, err := ParseToleration(testCase.input)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse toleration: %v", err)
+			}
+
+			if arch != testCase.arch {
+				t.Errorf("got != want: %s != %s", arch, testCase.arch)
+			}
+			if got != testCase.expected {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestTolerations(t *testing.T) {
+	arm := corev1.Toleration{Key: "arch", Operator: corev1.TolerationOpEqual, Value: "arm64", Effect: corev1.TaintEffectNoSchedule}
+	spot := corev1.Toleration{Key: "spot", Operator: corev1.TolerationOpExists}
+	ArchitectureTolerations = map[string][]corev1.Toleration{
+		"arm64":   {arm, spot},
+		"riscv64": {spot},
+	}
+	defer func() { ArchitectureTolerations = map[string][]corev1.Toleration{} }()
+
+	testCases := []struct {
+		name     string
+		present  []corev1.Toleration
+		arches   []string
+		expected []corev1.Toleration
+	}{
+		{
+			name:     "none",
+			arches:   []string{"amd64"},
+			expected: nil,
+		},
+		{
+			name:     "deduplicated",
+			arches:   []string{"riscv64", "arm64", "amd64"},
+			expected: []corev1.Toleration{arm, spot},
+		},
+		{
+			name:     "present",
+			present:  []corev1.Toleration{arm},
+			arches:   []string{"arm64"},
+			expected: []corev1.Toleration{spot},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Tolerations: testCase.present}}
+			got := tolerations(context.Background(), pod, testCase.arches)
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestHandlePodTolerations(t *testing.T) {
+	useNodes(t, "amd64", "arm64")
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		return &resources.Image{Reference: ref, Architectures: map[string]bool{"amd64": true, "arm64": true}}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+	arm := corev1.Toleration{Key: "arch", Operator: corev1.TolerationOpEqual, Value: "arm64", Effect: corev1.TaintEffectNoSchedule}
+	ArchitectureTolerations = map[string][]corev1.Toleration{"arm64": {arm}}
+	defer func() { ArchitectureTolerations = map[string][]corev1.Toleration{} }()
+
+	testCases := []struct {
+		name     string
+		present  []corev1.Toleration
+		expected []patchOperation
+	}{
+		{
+			name:     "new",
+			expected: []patchOperation{{Op: "add", Path: "/spec/tolerations", Value: []corev1.Toleration{arm}}},
+		},
+		{
+			name:     "append",
+			present:  []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists}},
+			expected: []patchOperation{{Op: "add", Path: "/spec/tolerations/-", Value: arm}},
+		},
+		{
+			name:    "present",
+			present: []corev1.Toleration{arm},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				Containers:  []corev1.Container{{Name: "app", Image: "image"}},
+				Tolerations: testCase.present,
+			}}
+			// The pod runs on all nodes, so only the tolerations are patched.
+			got, err := handlePod(context.Background(), pod)
+			if err != nil {
+				t.Fatalf("Failed to handle pod: %v", err)
+			}
+
+			want := ""
+			if testCase.expected != nil {
+				wantBytes, err := json.Marshal(testCase.expected)
+				if err != nil {
+					t.Fatalf("Failed to marshal patch: %v", err)
+				}
+				want = string(wantBytes)
+			}
+			if got != want {
+				t.Errorf("got != want: %s != %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/nodes"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// Overrides ArchitectureWeights on namespaces and pods, e.g. "arm64=50,amd64=10".
+// A weight of 0 removes the preference.
+const weightsAnnotation = "k8s-auto-arch.ongy.net/arch-weights"
+
+var (
+	// ArchitectureWeights make the scheduler prefer architectures of
+	// multi-arch pods, e.g. cheaper ones. They are between 1 and 100.
+	ArchitectureWeights = map[string]int32{}
+	// Capacity replaces ArchitectureWeights with weights derived from the free
+	// capacity of the nodes, if set.
+	Capacity *nodes.CapacityWeights
+	// Namespaces looks up the weight annotation of the pod's namespace, if
+	// set.
+	Namespaces listersv1.NamespaceLister
+)
+
+// ParseWeights parses the weights of weightsAnnotation.
+func ParseWeights(value string) (map[string]int32, error) {
+	weights := map[string]int32{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		arch, weightStr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("'%s' isn't arch=weight", part)
+		}
+		weight, err := strconv.ParseInt(strings.TrimSpace(weightStr), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parse weight of %s: %w", arch, err)
+		}
+		if weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("weight of %s is %d, not between 0 and 100", arch, weight)
+		}
+
+		weights[strings.TrimSpace(arch)] = int32(weight)
+	}
+
+	return weights, nil
+}
+
+// architectureWeights returns the weights for the pod. The annotations of its
+// namespace and then the pod override ArchitectureWeights (or the Capacity
+// ones) per architecture.
+func architectureWeights(ctx context.Context, pod *corev1.Pod) map[string]int32 {
+	ctx, span := otel.Tracer("").Start(ctx, "architectureWeights")
+	defer span.End()
+
+	weights := map[string]int32{}
+	base := ArchitectureWeights
+	if Capacity != nil {
+		base = Capacity.Weights()
+	}
+	for arch, weight := range base {
+		weights[arch] = weight
+	}
+	merge := func(kind, name string, annotations map[string]string) {
+		value, ok := annotations[weightsAnnotation]
+		if !ok {
+			return
+		}
+
+		overrides, err := ParseWeights(value)
+		if err != nil {
+			warnings.Add(ctx, "ignoring %s of %s %s: %v", weightsAnnotation, kind, name, err)
+			return
+		}
+		for arch, weight := range overrides {
+			weights[arch] = weight
+		}
+	}
+
+	if Namespaces != nil && pod.Namespace != "" {
+		namespace, err := Namespaces.Get(pod.Namespace)
+		if err != nil {
+			span.RecordError(err)
+		} else {
+			merge("namespace", namespace.Name, namespace.Annotations)
+		}
+	}
+	merge("pod", pod.Name, pod.Annotations)
+
+	return weights
+}
+
+// preferredTerms returns preferred scheduling terms for each of the arches
+// with a weight, one per ArchitectureLabels. There's nothing to prefer for
+// pods with a single arch.
+func preferredTerms(weights map[string]int32, arches []string) []corev1.PreferredSchedulingTerm {
+	if len(arches) < 2 {
+		return nil
+	}
+
+	sorted := slices.Clone(arches)
+	slices.Sort(sorted)
+
+	terms := []corev1.PreferredSchedulingTerm{}
+	for _, arch := range sorted {
+		if weights[arch] <= 0 {
+			continue
+		}
+
+		for _, term := range architectureTerms([]string{arch}) {
+			terms = append(terms, corev1.PreferredSchedulingTerm{Weight: weights[arch], Preference: term})
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	return terms
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func TestParseWeights(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected map[string]int32
+		err      bool
+	}{
+		{
+			name:     "simple",
+			input:    "arm64=50, amd64=10",
+			expected: map[string]int32{"arm64": 50, "amd64": 10},
+		},
+		{
+			name:     "empty",
+			input:    "",
+			expected: map[string]int32{},
+		},
+		{
+			name:  "format",
+			input: "arm64",
+			err:   true,
+		},
+		{
+			name:  "number",
+			input: "arm64=cheap",
+			err:   true,
+		},
+		{
+			name:  "range",
+			input: "arm64=101",
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := ParseWeights(testCase.input)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse weights: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestArchitectureWeights(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", Annotations: map[string]string{weightsAnnotation: "arm64=80,riscv64=10"}}})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "broken", Annotations: map[string]string{weightsAnnotation: "arm64"}}})
+	Namespaces = listersv1.NewNamespaceLister(indexer)
+	ArchitectureWeights = map[string]int32{"arm64": 50, "amd64": 10}
+	defer func() {
+		Namespaces = nil
+		ArchitectureWeights = map[string]int32{}
+	}()
+
+	testCases := []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		expected    map[string]int32
+		warnings    int
+	}{
+		{
+			name:      "default",
+			namespace: "default",
+			expected:  map[string]int32{"arm64": 50, "amd64": 10},
+		},
+		{
+			name:      "namespace",
+			namespace: "team",
+			expected:  map[string]int32{"arm64": 80, "amd64": 10, "riscv64": 10},
+		},
+		{
+			name:        "pod",
+			namespace:   "team",
+			annotations: map[string]string{weightsAnnotation: "arm64=0"},
+			expected:    map[string]int32{"arm64": 0, "amd64": 10, "riscv64": 10},
+		},
+		{
+			name:      "invalid",
+			namespace: "broken",
+			expected:  map[string]int32{"arm64": 50, "amd64": 10},
+			warnings:  1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := warnings.NewContext(context.Background())
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: testCase.namespace, Annotations: testCase.annotations}}
+
+			got := architectureWeights(ctx, pod)
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+			if len(warnings.FromContext(ctx)) != testCase.warnings {
+				t.Errorf("got != want: %v != %d warnings", warnings.FromContext(ctx), testCase.warnings)
+			}
+		})
+	}
+}
+
+func TestPreferredTerms(t *testing.T) {
+	weights := map[string]int32{"arm64": 50, "amd64": 0}
+
+	if got := preferredTerms(weights, []string{"arm64"}); got != nil {
+		t.Errorf("Expected no preference for a single arch, got: %v", got)
+	}
+	if got := preferredTerms(weights, []string{"amd64", "riscv64"}); got != nil {
+		t.Errorf("Expected no preference without weights, got: %v", got)
+	}
+
+	got := preferredTerms(weights, []string{"arm64", "amd64"})
+	if len(got) != 1 || got[0].Weight != 50 || got[0].Preference.MatchExpressions[0].Values[0] != "arm64" {
+		t.Errorf("Expected arm64 to be preferred, got: %v", got)
+	}
+}
@@ -0,0 +1,277 @@
+// Package imagearch shares resolved images between webhook replicas through
+// ImageArchitecture custom resources.
+package imagearch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+)
+
+const (
+	Group   = "k8s-auto-arch.ongy.net"
+	Version = "v1alpha1"
+	Kind    = "ImageArchitecture"
+
+	// Writes are queued, so publishing never delays an admission.
+	queueSize    = 128
+	writeTimeout = 10 * time.Second
+	resync       = 10 * time.Minute
+)
+
+// Resource is the ImageArchitecture resource.
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "imagearchitectures"}
+
+// Store mirrors ImageArchitecture resources into a cache, and publishes the
+// images the cache resolves and deletes the ones it invalidates.
+type Store struct {
+	client dynamic.Interface
+	cache  *resources.Cache
+	queue  chan queued
+}
+
+// queued is a write of the image, or its deletion.
+type queued struct {
+	image   *resources.Image
+	deleted bool
+}
+
+func NewStore(client dynamic.Interface, cache *resources.Cache) *Store {
+	return &Store{
+		client: client,
+		cache:  cache,
+		queue:  make(chan queued, queueSize),
+	}
+}
+
+// Name returns the name of the resource for an image. References aren't valid
+// object names, so they are hashed.
+func Name(refString string) string {
+	if ref, err := regname.ParseReference(refString); err == nil {
+		refString = ref.Name()
+	}
+
+	sum := sha256.Sum256([]byte(refString))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+func toObject(image *resources.Image) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"image":      image.Reference,
+		"resolvedAt": image.ResolvedAt.UTC().Format(time.RFC3339),
+	}
+	if image.Digest != "" {
+		spec["digest"] = image.Digest
+	}
+	if image.Source != "" {
+		spec["source"] = image.Source
+	}
+	if image.Architectures != nil {
+		arches := util.Keys(image.Architectures)
+		slices.Sort(arches)
+		platforms := []interface{}{}
+		for _, arch := range arches {
+			platforms = append(platforms, arch)
+		}
+		spec["platforms"] = platforms
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(Group + "/" + Version)
+	obj.SetKind(Kind)
+	obj.SetName(Name(image.Reference))
+
+	return obj
+}
+
+func fromObject(obj *unstructured.Unstructured) (*resources.Image, error) {
+	ref, _, err := unstructured.NestedString(obj.Object, "spec", "image")
+	if err != nil || ref == "" {
+		return nil, fmt.Errorf("%s has no spec.image", obj.GetName())
+	}
+
+	image := &resources.Image{Reference: ref}
+	image.Digest, _, _ = unstructured.NestedString(obj.Object, "spec", "digest")
+	image.Source, _, _ = unstructured.NestedString(obj.Object, "spec", "source")
+
+	resolvedAt, _, _ := unstructured.NestedString(obj.Object, "spec", "resolvedAt")
+	if resolvedAt != "" {
+		image.ResolvedAt, err = time.Parse(time.RFC3339, resolvedAt)
+		if err != nil {
+			return nil, fmt.Errorf("parse spec.resolvedAt of %s: %w", obj.GetName(), err)
+		}
+	}
+
+	platforms, found, err := unstructured.NestedStringSlice(obj.Object, "spec", "platforms")
+	if err != nil {
+		return nil, fmt.Errorf("parse spec.platforms of %s: %w", obj.GetName(), err)
+	}
+	if found {
+		image.Architectures = map[string]bool{}
+		for _, platform := range platforms {
+			image.Architectures[platform] = true
+		}
+	}
+
+	return image, nil
+}
+
+// Publish queues the image to be written to its resource. It drops the image
+// if the queue is full, the next resolution will publish it again.
+func (s *Store) Publish(image *resources.Image) {
+	select {
+	case s.queue <- queued{image: image}:
+	default:
+		slog.Warn("Dropping image, publish queue is full", "container", image.Reference)
+	}
+}
+
+// Unpublish queues the resource of the image to be deleted, so all replicas
+// drop the image.
+func (s *Store) Unpublish(refString string) {
+	select {
+	case s.queue <- queued{image: &resources.Image{Reference: refString}, deleted: true}:
+	default:
+		slog.Warn("Dropping invalidation, publish queue is full", "container", refString)
+	}
+}
+
+func (s *Store) write(ctx context.Context, image *resources.Image) error {
+	ctx, span := otel.Tracer("").Start(ctx, "Store.write", trace.WithAttributes(attribute.String("container", image.Reference)))
+	defer span.End()
+
+	client := s.client.Resource(Resource)
+	obj := toObject(image)
+
+	existing, err := client.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := client.Create(ctx, obj, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("create %s: %w", obj.GetName(), err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get %s: %w", obj.GetName(), err)
+	}
+
+	// Overrides of an admin must survive.
+	if source, _, _ := unstructured.NestedString(existing.Object, "spec", "source"); source == resources.SourceOverride {
+		return nil
+	}
+
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	if _, err := client.Update(ctx, obj, metav1.UpdateOptions{}); err != nil && !apierrors.IsConflict(err) {
+		return fmt.Errorf("update %s: %w", obj.GetName(), err)
+	}
+
+	return nil
+}
+
+func (s *Store) delete(ctx context.Context, refString string) error {
+	ctx, span := otel.Tracer("").Start(ctx, "Store.delete", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	client := s.client.Resource(Resource)
+	name := Name(refString)
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get %s: %w", name, err)
+	}
+
+	// Overrides of an admin must survive.
+	if source, _, _ := unstructured.NestedString(existing.Object, "spec", "source"); source == resources.SourceOverride {
+		return nil
+	}
+
+	// Only delete what was read, not an override written in the meantime.
+	resourceVersion := existing.GetResourceVersion()
+	err = client.Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion}})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (s *Store) put(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	image, err := fromObject(u)
+	if err != nil {
+		slog.Warn("Ignoring invalid ImageArchitecture", "err", err)
+		return
+	}
+
+	s.cache.Put(image)
+}
+
+func (s *Store) remove(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	if ref, _, _ := unstructured.NestedString(u.Object, "spec", "image"); ref != "" {
+		s.cache.Delete(ref)
+	}
+}
+
+// Run watches the resources and writes published images until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(s.client, resync)
+	informer := factory.ForResource(Resource).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    s.put,
+		UpdateFunc: func(_, obj interface{}) { s.put(obj) },
+		DeleteFunc: s.remove,
+	})
+	factory.Start(ctx.Done())
+
+	for {
+		select {
+		case item := <-s.queue:
+			writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+			if item.deleted {
+				if err := s.delete(writeCtx, item.image.Reference); err != nil {
+					slog.WarnContext(ctx, "Failed to unpublish image", "container", item.image.Reference, "err", err)
+				}
+			} else if err := s.write(writeCtx, item.image); err != nil {
+				slog.WarnContext(ctx, "Failed to publish image", "container", item.image.Reference, "err", err)
+			}
+			cancel()
+		case <-ctx.Done():
+			factory.Shutdown()
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package imagearch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+func newClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{Resource: Kind + "List"}, objects...)
+}
+
+var unresolvable = resources.ResolverFunc(func(context.Context, string, *corev1.Pod) (*resources.Image, error) {
+	return nil, errors.New("registry down")
+})
+
+func TestObjectRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name  string
+		image *resources.Image
+	}{
+		{
+			name: "platforms",
+			image: &resources.Image{
+				Reference:     "registry.local/org/image:1.0",
+				Digest:        "sha256:1234",
+				Architectures: map[string]bool{"amd64": true, "arm64": true},
+				Source:        resources.SourceIndex,
+				ResolvedAt:    time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "unconstrained",
+			image: &resources.Image{
+				Reference:  "registry.local/org/scratch:1.0",
+				Source:     resources.SourceUnconstrained,
+				ResolvedAt: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := fromObject(toObject(testCase.image))
+			if err != nil {
+				t.Fatalf("Failed to convert object: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, testCase.image) {
+				t.Errorf("got != want: %v != %v", got, testCase.image)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	resolvedAt := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	image := &resources.Image{Reference: "image:1.0", Architectures: map[string]bool{"arm64": true}, Source: resources.SourceIndex, ResolvedAt: resolvedAt}
+
+	existing := func(source string) *unstructured.Unstructured {
+		return toObject(&resources.Image{Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}, Source: source, ResolvedAt: resolvedAt})
+	}
+
+	testCases := []struct {
+		name     string
+		existing []runtime.Object
+		expected string
+	}{
+		{
+			name:     "create",
+			expected: "arm64",
+		},
+		{
+			name:     "update",
+			existing: []runtime.Object{existing(resources.SourceConfig)},
+			expected: "arm64",
+		},
+		{
+			name:     "override",
+			existing: []runtime.Object{existing(resources.SourceOverride)},
+			expected: "amd64",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newClient(testCase.existing...)
+			store := NewStore(client, resources.NewCache(unresolvable))
+
+			if err := store.write(context.Background(), image); err != nil {
+				t.Fatalf("Failed to write image: %v", err)
+			}
+
+			obj, err := client.Resource(Resource).Get(context.Background(), Name("image:1.0"), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get object: %v", err)
+			}
+			got, err := fromObject(obj)
+			if err != nil {
+				t.Fatalf("Failed to convert object: %v", err)
+			}
+
+			if !got.Architectures[testCase.expected] {
+				t.Errorf("got != want: %v != %s", got.Architectures, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	override := &resources.Image{Reference: "image:1.0", Architectures: map[string]bool{"riscv64": true}, Source: resources.SourceOverride, ResolvedAt: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)}
+	client := newClient()
+	cache := resources.NewCache(unresolvable)
+	store := NewStore(client, cache)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Run(ctx)
+
+	if _, err := client.Resource(Resource).Create(ctx, toObject(override), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create object: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := cache.Get(ctx, "image:1.0")
+		if err == nil {
+			if !got.Architectures["riscv64"] {
+				t.Errorf("got != want: %v != riscv64", got.Architectures)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Override didn't reach the cache: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.Resource(Resource).Delete(ctx, Name("image:1.0"), metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete object: %v", err)
+	}
+
+	for {
+		if _, err := cache.Get(ctx, "image:1.0"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Deleted override is still cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	resolvedAt := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	existing := func(source string) *unstructured.Unstructured {
+		return toObject(&resources.Image{Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}, Source: source, ResolvedAt: resolvedAt})
+	}
+
+	testCases := []struct {
+		name     string
+		existing []runtime.Object
+		deleted  bool
+	}{
+		{
+			name:    "missing",
+			deleted: true,
+		},
+		{
+			name:     "delete",
+			existing: []runtime.Object{existing(resources.SourceConfig)},
+			deleted:  true,
+		},
+		{
+			name:     "override",
+			existing: []runtime.Object{existing(resources.SourceOverride)},
+			deleted:  false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client := newClient(testCase.existing...)
+			store := NewStore(client, resources.NewCache(unresolvable))
+
+			if err := store.delete(context.Background(), "image:1.0"); err != nil {
+				t.Fatalf("Failed to delete image: %v", err)
+			}
+
+			_, err := client.Resource(Resource).Get(context.Background(), Name("image:1.0"), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) != testCase.deleted {
+				t.Errorf("got != want: %v != deleted %v", err, testCase.deleted)
+			}
+		})
+	}
+}
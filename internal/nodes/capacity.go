@@ -0,0 +1,250 @@
+package nodes
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// usage is what a pod requests from its node.
+type usage struct {
+	node   string
+	cpu    int64
+	memory int64
+}
+
+// podUsage returns the resources the pod requests, like the scheduler counts
+// them: init containers run one after another, the others at the same time.
+func podUsage(pod *corev1.Pod) usage {
+	ret := usage{node: pod.Spec.NodeName}
+	for _, container := range pod.Spec.Containers {
+		ret.cpu += container.Resources.Requests.Cpu().MilliValue()
+		ret.memory += container.Resources.Requests.Memory().Value()
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if cpu := container.Resources.Requests.Cpu().MilliValue(); cpu > ret.cpu {
+			ret.cpu = cpu
+		}
+		if memory := container.Resources.Requests.Memory().Value(); memory > ret.memory {
+			ret.memory = memory
+		}
+	}
+	ret.cpu += pod.Spec.Overhead.Cpu().MilliValue()
+	ret.memory += pod.Spec.Overhead.Memory().Value()
+
+	return ret
+}
+
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func (i *Inventory) putPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		delete(i.pods, podKey(pod))
+		return
+	}
+	i.pods[podKey(pod)] = podUsage(pod)
+}
+
+func (i *Inventory) removePod(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.pods, podKey(pod))
+}
+
+// WatchPods also tracks the resources requested by the pods on the nodes, for
+// Headroom. It has to be called before Run.
+func (i *Inventory) WatchPods() {
+	i.watchPods = true
+}
+
+// runPods watches the pods until ctx is done.
+func (i *Inventory) runPods(ctx context.Context) cache.InformerSynced {
+	// Terminated pods don't use their requests anymore.
+	factory := informers.NewSharedInformerFactoryWithOptions(i.client, resync, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.FieldSelector = "status.phase!=Succeeded,status.phase!=Failed"
+	}))
+	informer := factory.Core().V1().Pods().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    i.putPod,
+		UpdateFunc: func(_, obj interface{}) { i.putPod(obj) },
+		DeleteFunc: i.removePod,
+	})
+	factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		factory.Shutdown()
+	}()
+
+	return informer.HasSynced
+}
+
+// Headroom returns the share of allocatable CPU and memory that isn't
+// requested yet per architecture, whichever is scarcer. It is nil until the
+// nodes and pods were listed.
+func (i *Inventory) Headroom() map[string]float64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.synced || !i.watchPods {
+		return nil
+	}
+
+	type resources struct{ cpu, memory, usedCPU, usedMemory int64 }
+	arches := map[string]*resources{}
+	nodeArches := map[string]*resources{}
+	for name, node := range i.nodes {
+		arch := node.Labels[corev1.LabelArchStable]
+		if arch == "" || node.Spec.Unschedulable {
+			continue
+		}
+
+		if arches[arch] == nil {
+			arches[arch] = &resources{}
+		}
+		arches[arch].cpu += node.Status.Allocatable.Cpu().MilliValue()
+		arches[arch].memory += node.Status.Allocatable.Memory().Value()
+		nodeArches[name] = arches[arch]
+	}
+
+	for _, pod := range i.pods {
+		if arch, ok := nodeArches[pod.node]; ok {
+			arch.usedCPU += pod.cpu
+			arch.usedMemory += pod.memory
+		}
+	}
+
+	ret := map[string]float64{}
+	for arch, resources := range arches {
+		if resources.cpu <= 0 || resources.memory <= 0 {
+			ret[arch] = 0
+			continue
+		}
+
+		cpu := float64(resources.cpu-resources.usedCPU) / float64(resources.cpu)
+		memory := float64(resources.memory-resources.usedMemory) / float64(resources.memory)
+		ret[arch] = math.Max(0, math.Min(cpu, memory))
+	}
+
+	return ret
+}
+
+// CapacityWeights derives architecture weights from the headroom of the
+// nodes, so pods lean towards architectures with free capacity. The weights
+// are smoothed, so they don't flap with every scheduled pod.
+type CapacityWeights struct {
+	// MinWeight and MaxWeight bound the weights of full and empty
+	// architectures.
+	MinWeight, MaxWeight int32
+	// Smoothing is the share of a new headroom that goes into the weight on
+	// every update, between 0 and 1.
+	Smoothing float64
+
+	inventory *Inventory
+
+	mu      sync.RWMutex
+	weights map[string]float64
+}
+
+func NewCapacityWeights(inventory *Inventory) *CapacityWeights {
+	return &CapacityWeights{
+		MinWeight: 1,
+		MaxWeight: 100,
+		Smoothing: 0.3,
+		inventory: inventory,
+		weights:   map[string]float64{},
+	}
+}
+
+// update moves the weights towards the current headroom.
+func (c *CapacityWeights) update() {
+	headroom := c.inventory.Headroom()
+	if headroom == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	weights := map[string]float64{}
+	for arch, free := range headroom {
+		target := float64(c.MinWeight) + free*float64(c.MaxWeight-c.MinWeight)
+		previous, ok := c.weights[arch]
+		if !ok {
+			weights[arch] = target
+			continue
+		}
+		weights[arch] = previous + c.Smoothing*(target-previous)
+	}
+	c.weights = weights
+}
+
+// Weights returns the current weights.
+func (c *CapacityWeights) Weights() map[string]int32 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := map[string]int32{}
+	for arch, weight := range c.weights {
+		ret[arch] = int32(math.Round(weight))
+	}
+
+	return ret
+}
+
+// Run updates the weights every interval until ctx is done, and exposes them
+// as metrics.
+func (c *CapacityWeights) Run(ctx context.Context, interval time.Duration) {
+	_, err := otel.Meter("").Int64ObservableGauge("k8s_auto_arch.capacity.weight",
+		metric.WithDescription("Preference of architectures derived from the free capacity of their nodes"),
+		metric.WithInt64Callback(func(_ context.Context, observer metric.Int64Observer) error {
+			for arch, weight := range c.Weights() {
+				observer.Observe(int64(weight), metric.WithAttributes(attribute.String("arch", arch)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to register capacity weight metric", "err", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.update()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
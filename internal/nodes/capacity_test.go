@@ -0,0 +1,127 @@
+package nodes
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func requests(cpu, memory string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}}
+}
+
+func TestPodUsage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    corev1.PodSpec
+		expected usage
+	}{
+		{
+			name: "containers",
+			input: corev1.PodSpec{NodeName: "node", Containers: []corev1.Container{
+				{Resources: requests("500m", "1Gi")},
+				{Resources: requests("250m", "1Gi")},
+			}},
+			expected: usage{node: "node", cpu: 750, memory: 2 << 30},
+		},
+		{
+			name: "init",
+			input: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Resources: requests("2", "512Mi")}},
+				Containers:     []corev1.Container{{Resources: requests("500m", "1Gi")}},
+			},
+			expected: usage{cpu: 2000, memory: 1 << 30},
+		},
+		{
+			name: "overhead",
+			input: corev1.PodSpec{
+				Containers: []corev1.Container{{Resources: requests("500m", "1Gi")}},
+				Overhead:   requests("100m", "1Gi").Requests,
+			},
+			expected: usage{cpu: 600, memory: 2 << 30},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := podUsage(&corev1.Pod{Spec: testCase.input})
+			if got != testCase.expected {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func allocatable(n *corev1.Node, cpu, memory string) *corev1.Node {
+	n.Status.Allocatable = requests(cpu, memory).Requests
+	return n
+}
+
+func pod(name, node, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       corev1.PodSpec{NodeName: node, Containers: []corev1.Container{{Resources: requests(cpu, memory)}}},
+	}
+}
+
+func TestHeadroom(t *testing.T) {
+	inventory := NewInventory(fake.NewSimpleClientset())
+	inventory.WatchPods()
+	cordoned := allocatable(node("cordoned", "riscv64"), "4", "8Gi")
+	cordoned.Spec.Unschedulable = true
+	for _, node := range []*corev1.Node{allocatable(node("amd", "amd64"), "4", "8Gi"), allocatable(node("arm", "arm64"), "4", "8Gi"), allocatable(node("other-arm", "arm64"), "4", "8Gi"), cordoned} {
+		inventory.put(node)
+	}
+
+	// amd64 is short on memory, arm64 has 3 of 8 cores left.
+	inventory.putPod(pod("memory", "amd", "1", "6Gi"))
+	inventory.putPod(pod("cpu", "arm", "4", "1Gi"))
+	inventory.putPod(pod("small", "other-arm", "1", "1Gi"))
+	inventory.putPod(pod("pending", "", "4", "8Gi"))
+	done := pod("done", "other-arm", "4", "8Gi")
+	done.Status.Phase = corev1.PodSucceeded
+	inventory.putPod(done)
+
+	if got := inventory.Headroom(); got != nil {
+		t.Errorf("Expected no headroom before the sync, got: %v", got)
+	}
+	inventory.synced = true
+
+	want := map[string]float64{"amd64": 0.25, "arm64": 0.375}
+	if got := inventory.Headroom(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
+
+func TestCapacityWeights(t *testing.T) {
+	inventory := NewInventory(fake.NewSimpleClientset())
+	inventory.WatchPods()
+	inventory.synced = true
+	inventory.put(allocatable(node("arm", "arm64"), "4", "8Gi"))
+
+	weights := NewCapacityWeights(inventory)
+	weights.MinWeight = 0
+	weights.MaxWeight = 100
+	weights.Smoothing = 0.5
+
+	weights.update()
+	if got := weights.Weights(); got["arm64"] != 100 {
+		t.Errorf("got != want: %v != 100", got)
+	}
+
+	// Filling the node moves the weight halfway to the minimum per update.
+	inventory.putPod(pod("full", "arm", "4", "8Gi"))
+	for _, want := range []int32{50, 25, 13} {
+		weights.update()
+		if got := weights.Weights(); got["arm64"] != want {
+			t.Errorf("got != want: %d != %d", got["arm64"], want)
+		}
+	}
+}
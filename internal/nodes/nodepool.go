@@ -0,0 +1,89 @@
+package nodes
+
+import (
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	// NodePoolResource is the Karpenter NodePool resource. Its version can be
+	// set for clusters that still run a Karpenter before v1.
+	NodePoolResource = schema.GroupVersionResource{Group: "karpenter.sh", Version: "v1", Resource: "nodepools"}
+	// KarpenterArchitectures are the architectures Karpenter can provision
+	// nodes for, if a NodePool doesn't restrict them.
+	KarpenterArchitectures = []string{"amd64", "arm64"}
+)
+
+// nodePoolArchitectures returns the architectures a NodePool can provision,
+// from the kubernetes.io/arch requirement of its node template.
+func nodePoolArchitectures(pool *unstructured.Unstructured) (map[string]bool, error) {
+	requirements, _, err := unstructured.NestedSlice(pool.Object, "spec", "template", "spec", "requirements")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := map[string]bool{}
+	for _, arch := range KarpenterArchitectures {
+		ret[arch] = true
+	}
+
+	for _, requirement := range requirements {
+		fields, ok := requirement.(map[string]interface{})
+		if !ok || fields["key"] != corev1.LabelArchStable {
+			continue
+		}
+
+		operator, _, _ := unstructured.NestedString(fields, "operator")
+		values, _, _ := unstructured.NestedStringSlice(fields, "values")
+		switch corev1.NodeSelectorOperator(operator) {
+		case corev1.NodeSelectorOpIn:
+			ret = map[string]bool{}
+			for _, value := range values {
+				ret[value] = true
+			}
+		case corev1.NodeSelectorOpNotIn:
+			for _, value := range values {
+				delete(ret, value)
+			}
+		case corev1.NodeSelectorOpDoesNotExist:
+			ret = map[string]bool{}
+		}
+	}
+
+	return ret, nil
+}
+
+func (i *Inventory) putPool(obj interface{}) {
+	pool, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	arches, err := nodePoolArchitectures(pool)
+	if err != nil {
+		slog.Warn("Ignoring invalid NodePool", "name", pool.GetName(), "err", err)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pools[pool.GetName()] = arches
+}
+
+func (i *Inventory) removePool(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	pool, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.pools, pool.GetName())
+}
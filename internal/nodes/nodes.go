@@ -0,0 +1,166 @@
+// Package nodes keeps an inventory of the cluster's nodes, so pods are only
+// constrained to architectures that can actually be scheduled.
+package nodes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const resync = 10 * time.Minute
+
+// poolSyncTimeout bounds the wait for NodePools, which may not exist in the
+// cluster. Variable for testing.
+var poolSyncTimeout = time.Minute
+
+// Inventory tracks the nodes of the cluster through an informer, and the
+// architectures autoscalers can add nodes for.
+type Inventory struct {
+	// Provisionable architectures are available even without nodes, e.g.
+	// because an autoscaler adds nodes for them on demand.
+	Provisionable map[string]bool
+
+	client     kubernetes.Interface
+	poolClient dynamic.Interface
+	watchPods  bool
+
+	mu     sync.RWMutex
+	nodes  map[string]*corev1.Node
+	pools  map[string]map[string]bool
+	pods   map[string]usage
+	synced bool
+}
+
+func NewInventory(client kubernetes.Interface) *Inventory {
+	return &Inventory{
+		Provisionable: map[string]bool{},
+		client:        client,
+		nodes:         map[string]*corev1.Node{},
+		pools:         map[string]map[string]bool{},
+		pods:          map[string]usage{},
+	}
+}
+
+// WatchNodePools also treats the architectures of Karpenter NodePools as
+// available. It has to be called before Run.
+func (i *Inventory) WatchNodePools(client dynamic.Interface) {
+	i.poolClient = client
+}
+
+func (i *Inventory) put(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.nodes[node.Name] = node
+}
+
+func (i *Inventory) remove(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.nodes, node.Name)
+}
+
+// Run watches the nodes, NodePools and pods until ctx is done.
+func (i *Inventory) Run(ctx context.Context) {
+	factory := informers.NewSharedInformerFactory(i.client, resync)
+	informer := factory.Core().V1().Nodes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    i.put,
+		UpdateFunc: func(_, obj interface{}) { i.put(obj) },
+		DeleteFunc: i.remove,
+	})
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	synced := []cache.InformerSynced{informer.HasSynced}
+
+	var poolSynced cache.InformerSynced
+	if i.poolClient != nil {
+		poolFactory := dynamicinformer.NewDynamicSharedInformerFactory(i.poolClient, resync)
+		poolInformer := poolFactory.ForResource(NodePoolResource).Informer()
+		poolInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    i.putPool,
+			UpdateFunc: func(_, obj interface{}) { i.putPool(obj) },
+			DeleteFunc: i.removePool,
+		})
+		poolFactory.Start(ctx.Done())
+		defer poolFactory.Shutdown()
+		poolSynced = poolInformer.HasSynced
+	}
+
+	if i.watchPods {
+		synced = append(synced, i.runPods(ctx))
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+
+	// The NodePools are optional, the nodes are enough to restrict pods.
+	if poolSynced != nil {
+		poolCtx, cancel := context.WithTimeout(ctx, poolSyncTimeout)
+		if !cache.WaitForCacheSync(poolCtx.Done(), poolSynced) {
+			slog.WarnContext(ctx, "NodePools didn't sync, continuing without them until they do", "resource", NodePoolResource.String(), "timeout", poolSyncTimeout)
+		}
+		cancel()
+	}
+
+	i.mu.Lock()
+	i.synced = true
+	i.mu.Unlock()
+	slog.InfoContext(ctx, "Synced node inventory", "architectures", len(i.Architectures()))
+
+	<-ctx.Done()
+}
+
+// Architectures returns the architectures of the cluster's nodes. It is nil
+// until nodes were listed, so nothing is restricted before that.
+func (i *Inventory) Architectures() map[string]bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.synced {
+		return nil
+	}
+
+	ret := map[string]bool{}
+	for _, node := range i.nodes {
+		if arch := node.Labels[corev1.LabelArchStable]; arch != "" {
+			ret[arch] = true
+		}
+	}
+	for _, arches := range i.pools {
+		for arch := range arches {
+			ret[arch] = true
+		}
+	}
+	for arch := range i.Provisionable {
+		ret[arch] = true
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+
+	return ret
+}
@@ -0,0 +1,174 @@
+package nodes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func node(name, arch string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelArchStable: arch}}}
+}
+
+// waitFor polls the inventory until check is true.
+func waitFor(t *testing.T, check func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Inventory didn't catch up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	client := fake.NewSimpleClientset(node("amd", "amd64"), node("arm", "arm64"), node("other-arm", "arm64"))
+	inventory := NewInventory(client)
+
+	if got := inventory.Architectures(); got != nil {
+		t.Errorf("Expected no architectures before the sync, got: %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inventory.Run(ctx)
+
+	waitFor(t, func() bool { return inventory.Architectures() != nil })
+	want := map[string]bool{"amd64": true, "arm64": true}
+	if got := inventory.Architectures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+
+	if err := client.CoreV1().Nodes().Delete(ctx, "amd", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete node: %v", err)
+	}
+	if _, err := client.CoreV1().Nodes().Create(ctx, node("riscv", "riscv64"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+
+	want = map[string]bool{"arm64": true, "riscv64": true}
+	waitFor(t, func() bool { return reflect.DeepEqual(inventory.Architectures(), want) })
+}
+
+func nodePool(name string, requirements ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": NodePoolResource.GroupVersion().String(),
+		"kind":       "NodePool",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"requirements": requirements},
+			},
+		},
+	}}
+}
+
+func requirement(operator string, values ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"key": corev1.LabelArchStable, "operator": operator, "values": values}
+}
+
+func TestNodePoolArchitectures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    *unstructured.Unstructured
+		expected map[string]bool
+	}{
+		{
+			name:     "unrestricted",
+			input:    nodePool("pool"),
+			expected: map[string]bool{"amd64": true, "arm64": true},
+		},
+		{
+			name:     "in",
+			input:    nodePool("pool", requirement("In", "arm64")),
+			expected: map[string]bool{"arm64": true},
+		},
+		{
+			name:     "not-in",
+			input:    nodePool("pool", requirement("NotIn", "arm64")),
+			expected: map[string]bool{"amd64": true},
+		},
+		{
+			name:     "does-not-exist",
+			input:    nodePool("pool", requirement("DoesNotExist")),
+			expected: map[string]bool{},
+		},
+		{
+			name: "other-key",
+			input: nodePool("pool", map[string]interface{}{
+				"key": "karpenter.sh/capacity-type", "operator": "In", "values": []interface{}{"spot"},
+			}),
+			expected: map[string]bool{"amd64": true, "arm64": true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, err := nodePoolArchitectures(testCase.input)
+			if err != nil {
+				t.Fatalf("Failed to get architectures: %v", err)
+			}
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestInventoryProvisionable(t *testing.T) {
+	client := fake.NewSimpleClientset(node("amd", "amd64"))
+	poolClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{NodePoolResource: "NodePoolList"},
+		nodePool("arm", requirement("In", "arm64")))
+
+	inventory := NewInventory(client)
+	inventory.Provisionable = map[string]bool{"riscv64": true}
+	inventory.WatchNodePools(poolClient)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inventory.Run(ctx)
+
+	want := map[string]bool{"amd64": true, "arm64": true, "riscv64": true}
+	waitFor(t, func() bool { return reflect.DeepEqual(inventory.Architectures(), want) })
+
+	if err := poolClient.Resource(NodePoolResource).Delete(ctx, "arm", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete NodePool: %v", err)
+	}
+
+	want = map[string]bool{"amd64": true, "riscv64": true}
+	waitFor(t, func() bool { return reflect.DeepEqual(inventory.Architectures(), want) })
+}
+
+func TestInventoryNodePoolsMissing(t *testing.T) {
+	poolSyncTimeout = 100 * time.Millisecond
+	defer func() { poolSyncTimeout = time.Minute }()
+
+	client := fake.NewSimpleClientset(node("amd", "amd64"))
+	poolClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{NodePoolResource: "NodePoolList"})
+	poolClient.PrependReactor("list", NodePoolResource.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(NodePoolResource.GroupResource(), "")
+	})
+
+	inventory := NewInventory(client)
+	inventory.WatchNodePools(poolClient)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inventory.Run(ctx)
+
+	want := map[string]bool{"amd64": true}
+	waitFor(t, func() bool { return reflect.DeepEqual(inventory.Architectures(), want) })
+}
@@ -0,0 +1,89 @@
+package nodes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+)
+
+// blockingConstraint returns why the node can't host the pod, or "" if it
+// can. Only the first constraint that rules the node out is reported.
+func blockingConstraint(pod *corev1.Pod, node *corev1.Node) string {
+	if len(pod.Spec.NodeSelector) > 0 {
+		selector := labels.SelectorFromSet(pod.Spec.NodeSelector)
+		if !selector.Matches(labels.Set(node.Labels)) {
+			return fmt.Sprintf("didn't match nodeSelector %s", selector)
+		}
+	}
+
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil && affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		selector := nodeaffinity.NewLazyErrorNodeSelector(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+		if ok, _ := selector.Match(node); !ok {
+			return "didn't match the required node affinity"
+		}
+	}
+
+	taint, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(taint *corev1.Taint) bool {
+		return taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute
+	})
+	if untolerated {
+		return fmt.Sprintf("had untolerated taint %s", taint.ToString())
+	}
+
+	return ""
+}
+
+// Preflight checks whether any current node could host the pod. It returns
+// nil if one can or the nodes aren't known yet, and otherwise an error naming
+// the constraints that rule the nodes out.
+func (i *Inventory) Preflight(ctx context.Context, pod *corev1.Pod) error {
+	_, span := otel.Tracer("").Start(ctx, "Inventory.Preflight")
+	defer span.End()
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if !i.synced || len(i.nodes) == 0 {
+		return nil
+	}
+
+	blocked := map[string]int{}
+	for _, node := range i.nodes {
+		constraint := blockingConstraint(pod, node)
+		if constraint == "" {
+			return nil
+		}
+		blocked[constraint]++
+	}
+
+	constraints := maps.Keys(blocked)
+	slices.SortFunc(constraints, func(a, b string) int {
+		if blocked[a] != blocked[b] {
+			return blocked[b] - blocked[a]
+		}
+		return strings.Compare(a, b)
+	})
+	span.SetAttributes(attribute.StringSlice("constraints", constraints))
+
+	reasons := []string{}
+	for _, constraint := range constraints {
+		reasons = append(reasons, fmt.Sprintf("%d node(s) %s", blocked[constraint], constraint))
+	}
+
+	return fmt.Errorf("0/%d nodes can host the pod: %s", len(i.nodes), strings.Join(reasons, ", "))
+}
+
+// Autoscaled returns whether nodes are added on demand, so pods that no
+// current node can host may still be scheduled.
+func (i *Inventory) Autoscaled() bool {
+	return i.poolClient != nil || len(i.Provisionable) > 0
+}
@@ -0,0 +1,73 @@
+package nodes
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPreflight(t *testing.T) {
+	gpu := node("gpu", "arm64")
+	gpu.Labels["pool"] = "gpu-workers"
+	gpu.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+	inventory := NewInventory(fake.NewSimpleClientset())
+	for _, node := range []*corev1.Node{gpu, node("amd", "amd64"), node("other-amd", "amd64")} {
+		inventory.put(node)
+	}
+
+	amd64 := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: corev1.LabelArchStable, Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64"}},
+		}}},
+	}}}
+	toleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+
+	testCases := []struct {
+		name     string
+		input    corev1.PodSpec
+		expected string
+	}{
+		{
+			name:  "schedulable",
+			input: corev1.PodSpec{Affinity: amd64},
+		},
+		{
+			name:     "selector",
+			input:    corev1.PodSpec{NodeSelector: map[string]string{"pool": "gpu-workers"}, Affinity: amd64, Tolerations: []corev1.Toleration{toleration}},
+			expected: "0/3 nodes can host the pod: 2 node(s) didn't match nodeSelector pool=gpu-workers, 1 node(s) didn't match the required node affinity",
+		},
+		{
+			name:     "taint",
+			input:    corev1.PodSpec{NodeSelector: map[string]string{"pool": "gpu-workers"}},
+			expected: "0/3 nodes can host the pod: 2 node(s) didn't match nodeSelector pool=gpu-workers, 1 node(s) had untolerated taint dedicated=gpu:NoSchedule",
+		},
+		{
+			name:  "tolerated",
+			input: corev1.PodSpec{NodeSelector: map[string]string{"pool": "gpu-workers"}, Tolerations: []corev1.Toleration{toleration}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: testCase.input}
+
+			if err := inventory.Preflight(context.Background(), pod); err != nil {
+				t.Errorf("Expected unsynced inventory to pass, got: %v", err)
+			}
+
+			inventory.synced = true
+			defer func() { inventory.synced = false }()
+
+			err := inventory.Preflight(context.Background(), pod)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != testCase.expected {
+				t.Errorf("got != want: %q != %q", got, testCase.expected)
+			}
+		})
+	}
+}
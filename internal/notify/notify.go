@@ -0,0 +1,157 @@
+// Package notify handles push notifications of registries, to drop cached
+// platforms of tags as soon as they are pushed again.
+package notify
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+// Refreshes aren't bound to the notification request.
+const refreshTimeout = 30 * time.Second
+
+var (
+	// Secret has to be sent in the Authorization header of notifications,
+	// either as is or as bearer token.
+	Secret = ""
+	// Refresh resolves pushed images again right away, instead of waiting
+	// for the next pod to use them.
+	Refresh = false
+
+	// Indirection for testing
+	doInvalidate = resources.DefaultCache.Invalidate
+	doRefresh    = resources.DefaultCache.Get
+)
+
+// distributionEnvelope is the notification format of the distribution
+// registry.
+type distributionEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+			URL        string `json:"url"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// harborPayload is the webhook format of Harbor.
+type harborPayload struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+	} `json:"event_data"`
+}
+
+// notification holds the fields of both formats, to tell them apart.
+type notification struct {
+	distributionEnvelope
+	harborPayload
+}
+
+func authorized(r *http.Request) bool {
+	if Secret == "" {
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(Secret)) == 1
+}
+
+// pushedImages returns the tagged references the notification reports as
+// pushed. Pushes by digest and other events are ignored, digests never change.
+func pushedImages(n *notification) []string {
+	images := []string{}
+
+	for _, event := range n.Events {
+		if event.Action != "push" || event.Target.Tag == "" || event.Target.Repository == "" {
+			continue
+		}
+
+		host := event.Request.Host
+		if host == "" {
+			if target, err := url.Parse(event.Target.URL); err == nil {
+				host = target.Host
+			}
+		}
+		if host == "" {
+			continue
+		}
+
+		images = append(images, fmt.Sprintf("%s/%s:%s", host, event.Target.Repository, event.Target.Tag))
+	}
+
+	if n.Type == "PUSH_ARTIFACT" {
+		for _, resource := range n.EventData.Resources {
+			if resource.Tag == "" || resource.ResourceURL == "" {
+				continue
+			}
+			images = append(images, resource.ResourceURL)
+		}
+	}
+
+	return images
+}
+
+func refresh(image string) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+
+	if _, err := doRefresh(ctx, image); err != nil {
+		slog.WarnContext(ctx, "Failed to refresh pushed image", "container", image, "err", err)
+	}
+}
+
+// HandleRequest handles notifications of Distribution and Harbor registries.
+func HandleRequest(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("").Start(r.Context(), "notify.HandleRequest")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	n := notification{}
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		span.RecordError(err)
+		http.Error(w, fmt.Sprintf("decode notification: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	images := pushedImages(&n)
+	span.SetAttributes(attribute.StringSlice("images", images))
+	for _, image := range images {
+		slog.InfoContext(ctx, "Invalidating pushed image", "container", image)
+		doInvalidate(image)
+		if Refresh {
+			go refresh(image)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+const distributionPush = `{"events": [
+	{
+		"action": "push",
+		"target": {"mediaType": "application/vnd.oci.image.index.v1+json", "repository": "org/image", "tag": "1.0", "url": "https://registry.local/v2/org/image/manifests/sha256:1234"},
+		"request": {"host": "registry.local:5000"}
+	},
+	{
+		"action": "push",
+		"target": {"mediaType": "application/octet-stream", "repository": "org/image", "url": "https://registry.local/v2/org/image/blobs/sha256:5678"},
+		"request": {"host": "registry.local:5000"}
+	},
+	{
+		"action": "pull",
+		"target": {"repository": "org/other", "tag": "1.0"},
+		"request": {"host": "registry.local:5000"}
+	}
+]}`
+
+const harborPush = `{
+	"type": "PUSH_ARTIFACT",
+	"occur_at": 1693526400,
+	"operator": "admin",
+	"event_data": {
+		"resources": [{"digest": "sha256:1234", "tag": "latest", "resource_url": "harbor.local/library/nginx:latest"}],
+		"repository": {"name": "nginx", "namespace": "library", "repo_full_name": "library/nginx"}
+	}
+}`
+
+func TestHandleRequest(t *testing.T) {
+	testCases := []struct {
+		name          string
+		method        string
+		authorization string
+		body          string
+		status        int
+		expected      []string
+	}{
+		{
+			name:          "distribution",
+			method:        http.MethodPost,
+			authorization: "Bearer secret",
+			body:          distributionPush,
+			status:        http.StatusNoContent,
+			expected:      []string{"registry.local:5000/org/image:1.0"},
+		},
+		{
+			name:          "harbor",
+			method:        http.MethodPost,
+			authorization: "secret",
+			body:          harborPush,
+			status:        http.StatusNoContent,
+			expected:      []string{"harbor.local/library/nginx:latest"},
+		},
+		{
+			name:          "unauthorized",
+			method:        http.MethodPost,
+			authorization: "Bearer wrong",
+			body:          distributionPush,
+			status:        http.StatusUnauthorized,
+			expected:      []string{},
+		},
+		{
+			name:          "garbage",
+			method:        http.MethodPost,
+			authorization: "secret",
+			body:          "events",
+			status:        http.StatusBadRequest,
+			expected:      []string{},
+		},
+		{
+			name:          "method",
+			method:        http.MethodGet,
+			authorization: "secret",
+			status:        http.StatusMethodNotAllowed,
+			expected:      []string{},
+		},
+	}
+
+	Secret = "secret"
+	defer func() { Secret = "" }()
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			invalidated := []string{}
+			doInvalidate = func(ref string) bool {
+				invalidated = append(invalidated, ref)
+				return true
+			}
+			defer func() { doInvalidate = resources.DefaultCache.Invalidate }()
+
+			request := httptest.NewRequest(testCase.method, "/", strings.NewReader(testCase.body))
+			request.Header.Set("Authorization", testCase.authorization)
+			recorder := httptest.NewRecorder()
+
+			HandleRequest(recorder, request)
+
+			if recorder.Code != testCase.status {
+				t.Errorf("got != want: %d != %d", recorder.Code, testCase.status)
+			}
+			if !slices.Equal(invalidated, testCase.expected) {
+				t.Errorf("got != want: %v != %v", invalidated, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestNoSecret(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(distributionPush))
+	recorder := httptest.NewRecorder()
+
+	HandleRequest(recorder, request)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got != want: %d != %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
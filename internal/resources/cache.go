@@ -0,0 +1,406 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+const (
+	// Resolutions are shared by all callers waiting for them, so they aren't
+	// bound to the admission request that started them.
+	resolveTimeout = 30 * time.Second
+	// How often entries are checked against Retention.
+	evictInterval = time.Minute
+)
+
+var (
+	// DefaultCache holds the images resolved for pods.
+	DefaultCache = NewCache(Registry{})
+)
+
+type dryRunKey struct{}
+
+// WithDryRun marks ctx as belonging to a dry-run admission, whose resolutions
+// are cached but not published.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+type cacheEntry struct {
+	image      *Image
+	refreshing bool
+}
+
+// Cache remembers resolved images. Entries are fresh for TTL. Afterwards they
+// are still served for StaleGrace while they are refreshed in the background.
+// If resolving an image fails, the last known good answer is served until the
+// entry is older than TTL+StaleGrace+Retention. References by digest and
+// overrides never expire, but only overrides are retained forever.
+type Cache struct {
+	TTL        time.Duration
+	StaleGrace time.Duration
+	Retention  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// invalidated remembers when images were invalidated, so Put doesn't
+	// bring back what was resolved before.
+	invalidated map[string]time.Time
+	group       singleflight.Group
+	next        Resolver
+	lastEvict   time.Time
+
+	// Publish is called with every image the cache resolved itself, unless
+	// a dry-run admission started the resolution. It must not block.
+	Publish func(*Image)
+	// Unpublish is called with the reference of every image that was
+	// invalidated. It must not block.
+	Unpublish func(refString string)
+
+	// Indirection for testing
+	now func() time.Time
+}
+
+// NewCache returns a cache in front of next. Cached images don't depend on the
+// pod, so next is asked without one.
+func NewCache(next Resolver) *Cache {
+	return &Cache{
+		TTL:         5 * time.Minute,
+		StaleGrace:  time.Hour,
+		Retention:   24 * time.Hour,
+		entries:     map[string]*cacheEntry{},
+		invalidated: map[string]time.Time{},
+		next:        next,
+		now:         time.Now,
+	}
+}
+
+// cacheKey normalizes the reference, so different spellings of the same image
+// share an entry.
+func cacheKey(refString string) string {
+	ref, err := regname.ParseReference(refString)
+	if err != nil {
+		return refString
+	}
+
+	return ref.Name()
+}
+
+func isDigest(refString string) bool {
+	ref, err := regname.ParseReference(refString)
+	if err != nil {
+		return false
+	}
+
+	_, ok := ref.(regname.Digest)
+	return ok
+}
+
+// forRequest returns a copy of the cached image for the reference as it was
+// requested, and repeats the warnings of its resolution for the request.
+func forRequest(ctx context.Context, image *Image, refString string) *Image {
+	for _, warning := range image.Warnings {
+		warnings.Add(ctx, "%s", warning)
+	}
+
+	ret := *image
+	ret.Reference = refString
+	return &ret
+}
+
+// evict drops the entries past Retention. c.mu must be held.
+func (c *Cache) evict(now time.Time) {
+	if now.Sub(c.lastEvict) < evictInterval {
+		return
+	}
+	c.lastEvict = now
+
+	for key, entry := range c.entries {
+		if entry.image.Source != SourceOverride && now.Sub(entry.image.ResolvedAt) >= c.TTL+c.StaleGrace+c.Retention {
+			delete(c.entries, key)
+		}
+	}
+	for key, invalidatedAt := range c.invalidated {
+		if now.Sub(invalidatedAt) >= c.TTL+c.StaleGrace+c.Retention {
+			delete(c.invalidated, key)
+		}
+	}
+}
+
+// fetch resolves the image and stores it. Concurrent fetches of the same image
+// are collapsed into one, which outlives the ctx of the caller that started
+// it.
+func (c *Cache) fetch(ctx context.Context, key, refString string) (*Image, error) {
+	publish := c.Publish != nil && !isDryRun(ctx)
+	results := c.group.DoChan(key, func() (interface{}, error) {
+		resolveCtx, cancel := context.WithTimeout(trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx)), resolveTimeout)
+		defer cancel()
+		// The warnings are kept with the image for every request using it.
+		resolveCtx = warnings.NewContext(resolveCtx)
+
+		image, err := c.next.Resolve(resolveCtx, refString, nil)
+		if err != nil {
+			return nil, err
+		}
+		if image == nil {
+			return nil, fmt.Errorf("no resolver knows image %s", refString)
+		}
+
+		stored := *image
+		if stored.ResolvedAt.IsZero() {
+			stored.ResolvedAt = c.now()
+		}
+		stored.Warnings = warnings.FromContext(resolveCtx)
+
+		c.mu.Lock()
+		c.entries[key] = &cacheEntry{image: &stored}
+		c.evict(c.now())
+		c.mu.Unlock()
+
+		if publish {
+			c.Publish(&stored)
+		}
+
+		return &stored, nil
+	})
+
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return forRequest(ctx, result.Val.(*Image), refString), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for image %s: %w", refString, ctx.Err())
+	}
+}
+
+func (c *Cache) refresh(key, refString string) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	ctx, span := otel.Tracer("").Start(ctx, "Cache.refresh", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	if _, err := c.fetch(ctx, key, refString); err != nil {
+		span.RecordError(err)
+		slog.WarnContext(ctx, "Failed to refresh image", "container", refString, "err", err)
+
+		// An image that's no longer trusted must not be served from the cache.
+		if errors.Is(err, ErrUnsigned) {
+			c.Invalidate(refString)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if entry, ok := c.entries[key]; ok {
+			entry.refreshing = false
+		}
+	}
+}
+
+// Get returns the image, from the cache if possible.
+func (c *Cache) Get(ctx context.Context, refString string) (*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "Cache.Get", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	if c.TTL <= 0 {
+		span.SetAttributes(attribute.String("cache", "disabled"))
+		return c.next.Resolve(ctx, refString, nil)
+	}
+
+	key := cacheKey(refString)
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	var cached *Image
+	var age time.Duration
+	if ok {
+		cached = entry.image
+		age = now.Sub(cached.ResolvedAt)
+		span.SetAttributes(attribute.Float64("age", age.Seconds()))
+
+		if age < c.TTL || isDigest(refString) || cached.Source == SourceOverride {
+			c.mu.Unlock()
+			span.SetAttributes(attribute.String("cache", "fresh"))
+			return forRequest(ctx, cached, refString), nil
+		}
+
+		if age < c.TTL+c.StaleGrace {
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.refresh(key, refString)
+			}
+			c.mu.Unlock()
+
+			span.SetAttributes(attribute.String("cache", "stale"))
+			warnings.Add(ctx, "platforms of image %s were resolved %s ago, refreshing them in the background", refString, age.Round(time.Second))
+			return forRequest(ctx, cached, refString), nil
+		}
+	}
+	c.mu.Unlock()
+
+	image, err := c.fetch(ctx, key, refString)
+	if err == nil {
+		span.SetAttributes(attribute.String("cache", "miss"))
+		return image, nil
+	}
+
+	// An image that's no longer trusted must not be served from the cache.
+	if cached == nil || errors.Is(err, ErrUnsigned) {
+		return nil, err
+	}
+
+	span.RecordError(err)
+	span.SetAttributes(attribute.String("cache", "fallback"))
+	warnings.Add(ctx, "failed to resolve image %s, using platforms resolved %s ago: %v", refString, age.Round(time.Second), err)
+	return forRequest(ctx, cached, refString), nil
+}
+
+// Put stores an image resolved elsewhere. An entry that was resolved later is
+// kept, unless the new image is an override. Returns whether the image was
+// stored.
+func (c *Cache) Put(image *Image) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.put(image)
+}
+
+func (c *Cache) put(image *Image) bool {
+	key := cacheKey(image.Reference)
+	if invalidatedAt, ok := c.invalidated[key]; ok && image.Source != SourceOverride && !image.ResolvedAt.After(invalidatedAt) {
+		return false
+	}
+	if entry, ok := c.entries[key]; ok && image.Source != SourceOverride {
+		if entry.image.Source == SourceOverride || !entry.image.ResolvedAt.Before(image.ResolvedAt) {
+			return false
+		}
+	}
+
+	stored := *image
+	c.entries[key] = &cacheEntry{image: &stored}
+	return true
+}
+
+// Invalidate drops the image from the cache, so the next request resolves it
+// again, and unpublishes it. Overrides are kept, use Delete to drop them.
+// Returns whether the image was dropped.
+func (c *Cache) Invalidate(refString string) bool {
+	c.mu.Lock()
+	key := cacheKey(refString)
+	entry, ok := c.entries[key]
+	if ok && entry.image.Source == SourceOverride {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.entries, key)
+	c.invalidated[key] = c.now()
+	c.mu.Unlock()
+
+	// Other replicas may have resolved the image, even if this one didn't.
+	if c.Unpublish != nil {
+		c.Unpublish(refString)
+	}
+
+	return ok
+}
+
+// repositoryPrefix normalizes a registry or repository like cacheKey.
+func repositoryPrefix(prefix string) string {
+	// A lone registry would be taken as repository on docker hub.
+	if !strings.Contains(prefix, "/") && (strings.ContainsAny(prefix, ".:") || prefix == "localhost") {
+		if registry, err := regname.NewRegistry(prefix); err == nil {
+			return registry.Name()
+		}
+		return prefix
+	}
+
+	if repo, err := regname.NewRepository(prefix); err == nil {
+		return repo.Name()
+	}
+	return prefix
+}
+
+// InvalidateRepository invalidates all images in repositories starting with
+// prefix, e.g. all images of a registry or organization, like Invalidate.
+// Returns how many images were dropped.
+func (c *Cache) InvalidateRepository(prefix string) int {
+	prefix = repositoryPrefix(prefix)
+
+	c.mu.Lock()
+	now := c.now()
+	dropped := []string{}
+	for key, entry := range c.entries {
+		if entry.image.Source == SourceOverride || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// Don't drop org/image2 for org/image.
+		if rest := key[len(prefix):]; rest != "" && !strings.ContainsAny(rest[:1], "/:@") {
+			continue
+		}
+
+		delete(c.entries, key)
+		c.invalidated[key] = now
+		dropped = append(dropped, entry.image.Reference)
+	}
+	c.mu.Unlock()
+
+	if c.Unpublish != nil {
+		for _, ref := range dropped {
+			c.Unpublish(ref)
+		}
+	}
+
+	return len(dropped)
+}
+
+// Images returns copies of all cached images, sorted by reference.
+func (c *Cache) Images() []*Image {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	images := make([]*Image, 0, len(c.entries))
+	for _, entry := range c.entries {
+		image := *entry.image
+		images = append(images, &image)
+	}
+	slices.SortFunc(images, func(a, b *Image) int { return strings.Compare(a.Reference, b.Reference) })
+
+	return images
+}
+
+// Delete drops the image from the cache, including overrides.
+func (c *Cache) Delete(refString string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, cacheKey(refString))
+}
+
+// Resolve implements Resolver.
+func (c *Cache) Resolve(ctx context.Context, refString string, _ *corev1.Pod) (*Image, error) {
+	return c.Get(ctx, refString)
+}
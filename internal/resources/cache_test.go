@@ -0,0 +1,529 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+type fakeResolver struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+	arch  string
+	done  chan struct{}
+}
+
+func (r *fakeResolver) Resolve(_ context.Context, ref string, _ *corev1.Pod) (*Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.done != nil {
+		defer close(r.done)
+		r.done = nil
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return &Image{Reference: ref, Architectures: map[string]bool{r.arch: true}}, nil
+}
+
+func TestCache(t *testing.T) {
+	const digestRef = "image@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	testCases := []struct {
+		name string
+		ref  string
+		age  time.Duration
+		err  error
+		// Architecture expected in the result, empty if an error is expected.
+		expected   string
+		calls      int
+		background bool
+		warnings   int
+	}{
+		{
+			name:     "fresh",
+			ref:      "image:1.0",
+			age:      time.Minute,
+			expected: "amd64",
+			calls:    0,
+		},
+		{
+			name:       "stale",
+			ref:        "image:1.0",
+			age:        10 * time.Minute,
+			expected:   "amd64",
+			calls:      1,
+			background: true,
+			warnings:   1,
+		},
+		{
+			name:     "expired",
+			ref:      "image:1.0",
+			age:      2 * time.Hour,
+			expected: "arm64",
+			calls:    1,
+		},
+		{
+			name:     "fallback",
+			ref:      "image:1.0",
+			age:      2 * time.Hour,
+			err:      errors.New("registry down"),
+			expected: "amd64",
+			calls:    1,
+			warnings: 1,
+		},
+		{
+			name:  "unsigned",
+			ref:   "image:1.0",
+			age:   2 * time.Hour,
+			err:   fmt.Errorf("verify: %w", ErrUnsigned),
+			calls: 1,
+		},
+		{
+			name:     "digest",
+			ref:      digestRef,
+			age:      24 * time.Hour,
+			expected: "amd64",
+			calls:    0,
+		},
+		{
+			name:     "normalized",
+			ref:      "index.docker.io/library/image:1.0",
+			age:      time.Minute,
+			expected: "amd64",
+			calls:    0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			start := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+			now := start
+
+			resolver := &fakeResolver{arch: "amd64"}
+			cache := NewCache(resolver)
+			cache.now = func() time.Time { return now }
+
+			if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+				t.Fatalf("Failed to fill cache: %v", err)
+			}
+			if _, err := cache.Get(context.Background(), digestRef); err != nil {
+				t.Fatalf("Failed to fill cache: %v", err)
+			}
+
+			done := make(chan struct{})
+			resolver.mu.Lock()
+			resolver.calls = 0
+			resolver.arch = "arm64"
+			resolver.err = testCase.err
+			if testCase.background {
+				resolver.done = done
+			}
+			resolver.mu.Unlock()
+
+			now = start.Add(testCase.age)
+			ctx := warnings.NewContext(context.Background())
+			got, err := cache.Get(ctx, testCase.ref)
+
+			if testCase.expected == "" {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", got)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Failed to get image: %v", err)
+				}
+				if !got.Architectures[testCase.expected] || got.Reference != testCase.ref {
+					t.Errorf("got != want: %v != %s %s", got, testCase.ref, testCase.expected)
+				}
+			}
+
+			if testCase.background {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatalf("Background refresh didn't happen")
+				}
+			}
+
+			resolver.mu.Lock()
+			defer resolver.mu.Unlock()
+			if resolver.calls != testCase.calls {
+				t.Errorf("got != want: %d != %d calls", resolver.calls, testCase.calls)
+			}
+
+			if got := len(warnings.FromContext(ctx)); got != testCase.warnings {
+				t.Errorf("got != want: %d != %d warnings", got, testCase.warnings)
+			}
+		})
+	}
+}
+
+func TestCacheRefreshed(t *testing.T) {
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.now = func() time.Time { return now }
+
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to fill cache: %v", err)
+	}
+
+	resolver.mu.Lock()
+	resolver.arch = "arm64"
+	resolver.mu.Unlock()
+
+	now = now.Add(10 * time.Minute)
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+
+	// The refreshed entry is stored after the resolver returned.
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, err := cache.Get(context.Background(), "image:1.0")
+		if err != nil {
+			t.Fatalf("Failed to get image: %v", err)
+		}
+		if got.Architectures["arm64"] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected refreshed image, got: %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	if resolver.calls != 2 {
+		t.Errorf("got != want: %d != 2 calls", resolver.calls)
+	}
+}
+
+func TestCacheRefreshUnsigned(t *testing.T) {
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.now = func() time.Time { return now }
+	unpublished := make(chan string, 1)
+	cache.Unpublish = func(refString string) { unpublished <- refString }
+
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to fill cache: %v", err)
+	}
+
+	resolver.mu.Lock()
+	resolver.err = fmt.Errorf("%w: signature was revoked", ErrUnsigned)
+	resolver.mu.Unlock()
+
+	now = now.Add(10 * time.Minute)
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to get stale image: %v", err)
+	}
+
+	select {
+	case got := <-unpublished:
+		if got != "image:1.0" {
+			t.Errorf("got != want: %s != image:1.0", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the untrusted image to be unpublished")
+	}
+
+	if _, err := cache.Get(context.Background(), "image:1.0"); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected the untrusted image to be dropped, got: %v", err)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.TTL = 0
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+			t.Fatalf("Failed to get image: %v", err)
+		}
+	}
+
+	if resolver.calls != 2 {
+		t.Errorf("got != want: %d != 2 calls", resolver.calls)
+	}
+}
+
+func TestCachePut(t *testing.T) {
+	older := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	testCases := []struct {
+		name     string
+		existing *Image
+		image    *Image
+		stored   bool
+	}{
+		{
+			name:   "new",
+			image:  &Image{Reference: "image:1.0", ResolvedAt: older},
+			stored: true,
+		},
+		{
+			name:     "newer",
+			existing: &Image{Reference: "image:1.0", ResolvedAt: older},
+			image:    &Image{Reference: "image:1.0", ResolvedAt: newer},
+			stored:   true,
+		},
+		{
+			name:     "older",
+			existing: &Image{Reference: "image:1.0", ResolvedAt: newer},
+			image:    &Image{Reference: "image:1.0", ResolvedAt: older},
+			stored:   false,
+		},
+		{
+			name:     "override",
+			existing: &Image{Reference: "image:1.0", ResolvedAt: newer},
+			image:    &Image{Reference: "image:1.0", Source: SourceOverride, ResolvedAt: older},
+			stored:   true,
+		},
+		{
+			name:     "overridden",
+			existing: &Image{Reference: "image:1.0", Source: SourceOverride, ResolvedAt: older},
+			image:    &Image{Reference: "image:1.0", ResolvedAt: newer},
+			stored:   false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cache := NewCache(nil)
+			if testCase.existing != nil {
+				cache.Put(testCase.existing)
+			}
+
+			if got := cache.Put(testCase.image); got != testCase.stored {
+				t.Errorf("got != want: %v != %v", got, testCase.stored)
+			}
+		})
+	}
+}
+
+func TestCacheOverride(t *testing.T) {
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.Put(&Image{Reference: "image:1.0", Architectures: map[string]bool{"riscv64": true}, Source: SourceOverride})
+
+	got, err := cache.Get(context.Background(), "image:1.0")
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if !got.Architectures["riscv64"] || resolver.calls != 0 {
+		t.Errorf("Expected override to be served without resolving, got: %v after %d calls", got, resolver.calls)
+	}
+
+	cache.Invalidate("image:1.0")
+	got, err = cache.Get(context.Background(), "image:1.0")
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if !got.Architectures["riscv64"] || resolver.calls != 0 {
+		t.Errorf("Expected override to survive invalidation, got: %v after %d calls", got, resolver.calls)
+	}
+
+	cache.Delete("image:1.0")
+	got, err = cache.Get(context.Background(), "image:1.0")
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if !got.Architectures["amd64"] || resolver.calls != 1 {
+		t.Errorf("Expected deleted image to be resolved, got: %v after %d calls", got, resolver.calls)
+	}
+}
+
+func TestInvalidateRepository(t *testing.T) {
+	testCases := []struct {
+		name     string
+		prefix   string
+		dropped  int
+		expected []string
+	}{
+		{
+			name:     "organization",
+			prefix:   "registry.local/org",
+			dropped:  2,
+			expected: []string{"nginx:1.0", "registry.local/other/image:1.0", "registry.local/override:1.0"},
+		},
+		{
+			name:     "repository",
+			prefix:   "registry.local/org/image",
+			dropped:  1,
+			expected: []string{"nginx:1.0", "registry.local/org/image2:1.0", "registry.local/other/image:1.0", "registry.local/override:1.0"},
+		},
+		{
+			name:     "docker",
+			prefix:   "nginx",
+			dropped:  1,
+			expected: []string{"registry.local/org/image2:1.0", "registry.local/org/image:1.0", "registry.local/other/image:1.0", "registry.local/override:1.0"},
+		},
+		{
+			name:     "override",
+			prefix:   "registry.local",
+			dropped:  3,
+			expected: []string{"nginx:1.0", "registry.local/override:1.0"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cache := NewCache(nil)
+			for _, ref := range []string{"registry.local/org/image:1.0", "registry.local/org/image2:1.0", "registry.local/other/image:1.0", "nginx:1.0"} {
+				cache.Put(&Image{Reference: ref})
+			}
+			cache.Put(&Image{Reference: "registry.local/override:1.0", Source: SourceOverride})
+
+			if got := cache.InvalidateRepository(testCase.prefix); got != testCase.dropped {
+				t.Errorf("got != want: %d != %d dropped", got, testCase.dropped)
+			}
+
+			got := []string{}
+			for _, image := range cache.Images() {
+				got = append(got, image.Reference)
+			}
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestCacheWarnings(t *testing.T) {
+	cache := NewCache(ResolverFunc(func(ctx context.Context, ref string, _ *corev1.Pod) (*Image, error) {
+		warnings.Add(ctx, "image %s: dropping platform linux/arm64", ref)
+		return &Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}, nil
+	}))
+
+	// The warning of the resolution is repeated for the cache hit.
+	for i := 0; i < 2; i++ {
+		ctx := warnings.NewContext(context.Background())
+		if _, err := cache.Get(ctx, "image:1.0"); err != nil {
+			t.Fatalf("Failed to get image: %v", err)
+		}
+
+		want := []string{"image image:1.0: dropping platform linux/arm64"}
+		if got := warnings.FromContext(ctx); !slices.Equal(got, want) {
+			t.Errorf("got != want: %v != %v", got, want)
+		}
+	}
+}
+
+func TestCacheCancelled(t *testing.T) {
+	release := make(chan struct{})
+	cache := NewCache(ResolverFunc(func(ctx context.Context, ref string, _ *corev1.Pod) (*Image, error) {
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &Image{Reference: ref, Architectures: map[string]bool{"amd64": true}}, nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cache.Get(ctx, "image:1.0")
+		first <- err
+	}()
+
+	// The second caller joins the resolution the first one started.
+	second := make(chan error)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := cache.Get(context.Background(), "image:1.0")
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the first caller to be cancelled, got: %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("Failed to get image for the second caller: %v", err)
+	}
+}
+
+func TestCacheRetention(t *testing.T) {
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.now = func() time.Time { return now }
+	cache.Put(&Image{Reference: "old:1.0", ResolvedAt: now.Add(-cache.TTL - cache.StaleGrace - cache.Retention)})
+	cache.Put(&Image{Reference: "fallback:1.0", ResolvedAt: now.Add(-cache.TTL - cache.StaleGrace)})
+	cache.Put(&Image{Reference: "override:1.0", Source: SourceOverride, ResolvedAt: now.Add(-365 * 24 * time.Hour)})
+
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+
+	got := []string{}
+	for _, image := range cache.Images() {
+		got = append(got, image.Reference)
+	}
+	want := []string{"fallback:1.0", "image:1.0", "override:1.0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
+
+func TestCacheDryRun(t *testing.T) {
+	cache := NewCache(&fakeResolver{arch: "amd64"})
+	published := []string{}
+	cache.Publish = func(image *Image) { published = append(published, image.Reference) }
+
+	if _, err := cache.Get(WithDryRun(context.Background()), "dry:1.0"); err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+
+	if want := []string{"image:1.0"}; !slices.Equal(published, want) {
+		t.Errorf("got != want: %v != %v", published, want)
+	}
+}
+
+func TestCacheInvalidated(t *testing.T) {
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewCache(&fakeResolver{arch: "amd64"})
+	cache.now = func() time.Time { return now }
+	unpublished := []string{}
+	cache.Unpublish = func(ref string) { unpublished = append(unpublished, ref) }
+
+	old := &Image{Reference: "image:1.0", Architectures: map[string]bool{"amd64": true}, ResolvedAt: now.Add(-time.Minute)}
+	cache.Put(old)
+	if !cache.Invalidate("image:1.0") {
+		t.Errorf("Expected the image to be dropped")
+	}
+	if want := []string{"image:1.0"}; !slices.Equal(unpublished, want) {
+		t.Errorf("got != want: %v != %v", unpublished, want)
+	}
+
+	// A resync of the shared cache must not bring back the old platforms.
+	if cache.Put(old) {
+		t.Errorf("Expected the invalidated image to be rejected")
+	}
+	if !cache.Put(&Image{Reference: "image:1.0", ResolvedAt: now.Add(time.Second)}) {
+		t.Errorf("Expected the image resolved after the invalidation to be stored")
+	}
+}
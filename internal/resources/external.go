@@ -0,0 +1,189 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// externalVersion is the version of the external resolver protocol.
+const externalVersion = 1
+
+// externalRequest is POSTed to the external resolver.
+type externalRequest struct {
+	Version int    `json:"version"`
+	Image   string `json:"image"`
+	// Namespace and PullSecrets of the pod. They are empty if the image isn't
+	// resolved for a pod.
+	Namespace   string   `json:"namespace,omitempty"`
+	PullSecrets []string `json:"pullSecrets,omitempty"`
+}
+
+// externalResponse is returned by the external resolver. Unknown images are
+// answered with 404, so the next resolver is asked.
+type externalResponse struct {
+	Version   int      `json:"version"`
+	Digest    string   `json:"digest,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+	// Unconstrained images run on any architecture.
+	Unconstrained bool `json:"unconstrained,omitempty"`
+	// TTLSeconds is how long the answer may be reused. 0 asks again for
+	// every pod.
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+}
+
+type externalEntry struct {
+	image   *Image
+	expires time.Time
+}
+
+// External delegates the lookup to an HTTP endpoint, e.g. an artifact catalog
+// that already knows the platforms of every image. Answers are reused for the
+// TTL the endpoint returns.
+type External struct {
+	URL    string
+	Client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]externalEntry
+	now     func() time.Time
+}
+
+func NewExternal(url string, client *http.Client) *External {
+	return &External{
+		URL:     url,
+		Client:  client,
+		entries: map[string]externalEntry{},
+		now:     time.Now,
+	}
+}
+
+// ExternalClient returns a client for an External with the given timeout. It
+// verifies the server with the CA in caFile and authenticates with the
+// certificate in certFile and keyFile, if they are set.
+func ExternalClient(timeout time.Duration, caFile, certFile, keyFile string) (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		content, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("client certificate and key are required together")
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+func externalRequestFor(refString string, pod *corev1.Pod) externalRequest {
+	request := externalRequest{Version: externalVersion, Image: refString}
+	if pod == nil {
+		return request
+	}
+
+	request.Namespace = pod.Namespace
+	for _, secret := range pod.Spec.ImagePullSecrets {
+		request.PullSecrets = append(request.PullSecrets, secret.Name)
+	}
+
+	return request
+}
+
+func (e *External) Resolve(ctx context.Context, refString string, pod *corev1.Pod) (*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "External.Resolve", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	request := externalRequestFor(refString, pod)
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	key := string(body)
+
+	e.mu.Lock()
+	entry, ok := e.entries[key]
+	if ok && e.now().Before(entry.expires) {
+		e.mu.Unlock()
+		span.SetAttributes(attribute.Bool("cached", true))
+		return entry.image, nil
+	}
+	delete(e.entries, key)
+	e.mu.Unlock()
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := e.Client.Do(httpRequest)
+	if err != nil {
+		return nil, fmt.Errorf("ask external resolver: %w", err)
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(httpResponse.Body, 1024))
+		return nil, fmt.Errorf("ask external resolver: %s: %s", httpResponse.Status, strings.TrimSpace(string(message)))
+	}
+
+	response := externalResponse{}
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if response.Version != externalVersion {
+		return nil, fmt.Errorf("unsupported external resolver version %d", response.Version)
+	}
+	if response.Unconstrained == (len(response.Platforms) > 0) {
+		return nil, fmt.Errorf("external resolver returned %d platforms for unconstrained=%v", len(response.Platforms), response.Unconstrained)
+	}
+
+	image := &Image{Reference: refString, Digest: response.Digest, Source: SourceExternal, ResolvedAt: e.now()}
+	if !response.Unconstrained {
+		image.Architectures, image.Reported = normalizedArchitectures(ctx, refString, response.Platforms)
+	}
+	span.SetAttributes(attribute.StringSlice("platforms", response.Platforms), attribute.Int("ttl", response.TTLSeconds))
+
+	if response.TTLSeconds > 0 {
+		e.mu.Lock()
+		e.entries[key] = externalEntry{image: image, expires: image.ResolvedAt.Add(time.Duration(response.TTLSeconds) * time.Second)}
+		e.mu.Unlock()
+	}
+
+	return image, nil
+}
@@ -0,0 +1,224 @@
+package resources
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExternal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		response string
+		expected *Image
+		err      bool
+	}{
+		{
+			name:     "platforms",
+			status:   http.StatusOK,
+			response: `{"version": 1, "digest": "sha256:1234", "platforms": ["amd64", "arm64"]}`,
+			expected: &Image{Reference: "image:1.0", Digest: "sha256:1234", Architectures: map[string]bool{"amd64": true, "arm64": true}, Source: SourceExternal},
+		},
+		{
+			name:     "unconstrained",
+			status:   http.StatusOK,
+			response: `{"version": 1, "unconstrained": true}`,
+			expected: &Image{Reference: "image:1.0", Source: SourceExternal},
+		},
+		{
+			name:   "unknown",
+			status: http.StatusNotFound,
+		},
+		{
+			name:     "failed",
+			status:   http.StatusInternalServerError,
+			response: "catalog down",
+			err:      true,
+		},
+		{
+			name:     "version",
+			status:   http.StatusOK,
+			response: `{"version": 2, "platforms": ["amd64"]}`,
+			err:      true,
+		},
+		{
+			name:     "empty",
+			status:   http.StatusOK,
+			response: `{"version": 1}`,
+			err:      true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.status)
+				w.Write([]byte(testCase.response))
+			}))
+			defer server.Close()
+
+			now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+			external := NewExternal(server.URL, server.Client())
+			external.now = func() time.Time { return now }
+
+			image, err := external.Resolve(context.Background(), "image:1.0", nil)
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", image)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to resolve: %v", err)
+			}
+
+			if testCase.expected != nil {
+				testCase.expected.ResolvedAt = now
+			}
+			if !reflect.DeepEqual(image, testCase.expected) {
+				t.Errorf("got != want: %v != %v", image, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestExternalRequest(t *testing.T) {
+	requests := []externalRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := externalRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requests = append(requests, request)
+		w.Write([]byte(`{"version": 1, "platforms": ["amd64"], "ttlSeconds": 60}`))
+	}))
+	defer server.Close()
+
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	external := NewExternal(server.URL, server.Client())
+	external.now = func() time.Time { return now }
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team"},
+		Spec:       corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "catalog"}}},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := external.Resolve(context.Background(), "image:1.0", pod); err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+	}
+
+	want := []externalRequest{{Version: externalVersion, Image: "image:1.0", Namespace: "team", PullSecrets: []string{"catalog"}}}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got != want: %v != %v", requests, want)
+	}
+
+	// Other pods ask for themselves, expired answers are asked again.
+	if _, err := external.Resolve(context.Background(), "image:1.0", nil); err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := external.Resolve(context.Background(), "image:1.0", pod); err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if len(requests) != 3 {
+		t.Errorf("got != want: %d != 3 requests", len(requests))
+	}
+}
+
+// writeCertificate writes a self-signed certificate and its key to dir.
+func writeCertificate(t *testing.T, dir, name string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return certFile, keyFile, certificate
+}
+
+func TestExternalClient(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, certificate := writeCertificate(t, dir, "client")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": 1, "platforms": ["amd64"]}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(certificate)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	testCases := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		err      bool
+	}{
+		{
+			name:     "mtls",
+			certFile: certFile,
+			keyFile:  keyFile,
+		},
+		{
+			name: "anonymous",
+			err:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			client, err := ExternalClient(time.Second, caFile, testCase.certFile, testCase.keyFile)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+
+			_, err = NewExternal(server.URL, client).Resolve(context.Background(), "image:1.0", nil)
+			if (err != nil) != testCase.err {
+				t.Errorf("got != want: %v != %v error", err, testCase.err)
+			}
+		})
+	}
+
+	if _, err := ExternalClient(time.Second, caFile, certFile, ""); err == nil {
+		t.Errorf("Expected a certificate without key to fail")
+	}
+}
@@ -0,0 +1,189 @@
+package resources
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// EmptyArchPolicy decides what happens with images whose config doesn't
+// record an architecture.
+type EmptyArchPolicy string
+
+const (
+	// EmptyArchUnconstrained lets such images run on any architecture.
+	EmptyArchUnconstrained EmptyArchPolicy = "unconstrained"
+	// EmptyArchDefault assumes DefaultArchitecture.
+	EmptyArchDefault EmptyArchPolicy = "default"
+	// EmptyArchInspect looks for an ELF binary in the first layer, falling
+	// back to DefaultArchitecture (if set) or unconstrained.
+	EmptyArchInspect EmptyArchPolicy = "inspect"
+)
+
+// Where the architecture of an image came from.
+const (
+	SourceIndex         = "index"
+	SourceConfig        = "config"
+	SourceELF           = "elf"
+	SourceDefault       = "default"
+	SourceUnconstrained = "unconstrained"
+	SourceLayout        = "layout"
+	SourceExternal      = "external"
+	// SourceFallback marks images that couldn't be resolved.
+	SourceFallback = "fallback"
+	// SourceOverride marks images an admin configured by hand. They are never
+	// resolved again.
+	SourceOverride = "override"
+)
+
+var (
+	// EmptyArchitecture is applied to images without architecture.
+	EmptyArchitecture = EmptyArchUnconstrained
+	// DefaultArchitecture is assumed for images without architecture by
+	// EmptyArchDefault and EmptyArchInspect.
+	DefaultArchitecture = ""
+
+	errNoELF = errors.New("no ELF binary found")
+)
+
+func ParseEmptyArchPolicy(policy string) (EmptyArchPolicy, error) {
+	switch EmptyArchPolicy(policy) {
+	case EmptyArchUnconstrained, EmptyArchDefault, EmptyArchInspect:
+		return EmptyArchPolicy(policy), nil
+	}
+
+	return EmptyArchUnconstrained, fmt.Errorf("unknown empty architecture policy '%s'", policy)
+}
+
+// elfArchitecture returns the GOARCH of the ELF binary starting with header.
+func elfArchitecture(header []byte) (string, bool) {
+	if len(header) < 20 || !bytes.Equal(header[:4], []byte(elf.ELFMAG)) {
+		return "", false
+	}
+
+	class := elf.Class(header[elf.EI_CLASS])
+	var order binary.ByteOrder = binary.LittleEndian
+	if elf.Data(header[elf.EI_DATA]) == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	little := order == binary.LittleEndian
+
+	switch elf.Machine(order.Uint16(header[18:20])) {
+	case elf.EM_X86_64:
+		return "amd64", true
+	case elf.EM_386:
+		return "386", true
+	case elf.EM_AARCH64:
+		return "arm64", true
+	case elf.EM_ARM:
+		return "arm", true
+	case elf.EM_PPC64:
+		if little {
+			return "ppc64le", true
+		}
+		return "ppc64", true
+	case elf.EM_S390:
+		return "s390x", true
+	case elf.EM_RISCV:
+		if class == elf.ELFCLASS64 {
+			return "riscv64", true
+		}
+	case elf.EM_LOONGARCH:
+		return "loong64", true
+	case elf.EM_MIPS:
+		arch := "mips"
+		if class == elf.ELFCLASS64 {
+			arch = "mips64"
+		}
+		if little {
+			arch += "le"
+		}
+		return arch, true
+	}
+
+	return "", false
+}
+
+// inspectArchitecture returns the architecture of the first ELF binary found
+// in the first layer of image.
+func inspectArchitecture(ctx context.Context, image registryv1.Image) (string, error) {
+	_, span := otel.Tracer("").Start(ctx, "inspectArchitecture")
+	defer span.End()
+
+	layers, err := image.Layers()
+	if err != nil {
+		return "", fmt.Errorf("get layers: %w", err)
+	}
+	if len(layers) == 0 {
+		return "", errNoELF
+	}
+
+	content, err := layers[0].Uncompressed()
+	if err != nil {
+		return "", fmt.Errorf("get layer content: %w", err)
+	}
+	defer content.Close()
+
+	reader := tar.NewReader(content)
+	header := make([]byte, 20)
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return "", errNoELF
+		}
+		if err != nil {
+			return "", fmt.Errorf("read layer: %w", err)
+		}
+
+		if entry.Typeflag != tar.TypeReg || entry.Size < int64(len(header)) {
+			continue
+		}
+
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return "", fmt.Errorf("read '%s': %w", entry.Name, err)
+		}
+
+		if arch, ok := elfArchitecture(header); ok {
+			span.SetAttributes(attribute.String("file", entry.Name), attribute.String("arch", arch))
+			return arch, nil
+		}
+	}
+}
+
+// emptyArchitecture applies EmptyArchitecture to an image without
+// architecture. A nil set means the image doesn't constrain the pod.
+func emptyArchitecture(ctx context.Context, refString string, image registryv1.Image) (map[string]bool, string) {
+	ctx, span := otel.Tracer("").Start(ctx, "emptyArchitecture", trace.WithAttributes(attribute.String("policy", string(EmptyArchitecture))))
+	defer span.End()
+
+	if EmptyArchitecture == EmptyArchInspect {
+		arch, err := inspectArchitecture(ctx, image)
+		if err == nil {
+			warnings.Add(ctx, "image %s has no architecture in its config, detected %s from its first layer", refString, arch)
+			return map[string]bool{arch: true}, SourceELF
+		}
+
+		span.RecordError(err)
+		warnings.Add(ctx, "image %s has no architecture in its config and inspecting it failed: %v", refString, err)
+	}
+
+	if EmptyArchitecture != EmptyArchUnconstrained && DefaultArchitecture != "" {
+		warnings.Add(ctx, "image %s has no architecture in its config, assuming %s", refString, DefaultArchitecture)
+		return map[string]bool{DefaultArchitecture: true}, SourceDefault
+	}
+
+	warnings.Add(ctx, "image %s has no architecture in its config, not constraining the pod for it", refString)
+	return nil, SourceUnconstrained
+}
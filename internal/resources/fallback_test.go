@@ -0,0 +1,235 @@
+package resources
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"golang.org/x/exp/slices"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func makeELFHeader(class elf.Class, data elf.Data, machine elf.Machine) []byte {
+	header := make([]byte, 64)
+	copy(header, elf.ELFMAG)
+	header[elf.EI_CLASS] = byte(class)
+	header[elf.EI_DATA] = byte(data)
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if data == elf.ELFDATA2MSB {
+		order = binary.BigEndian
+	}
+	order.PutUint16(header[18:20], uint16(machine))
+
+	return header
+}
+
+func TestElfArchitecture(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []byte
+		expected string
+		ok       bool
+	}{
+		{
+			name:  "empty",
+			input: []byte{},
+			ok:    false,
+		},
+		{
+			name:  "script",
+			input: []byte("#!/bin/sh\necho hello world\n"),
+			ok:    false,
+		},
+		{
+			name:     "amd64",
+			input:    makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_X86_64),
+			expected: "amd64",
+			ok:       true,
+		},
+		{
+			name:     "arm64",
+			input:    makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_AARCH64),
+			expected: "arm64",
+			ok:       true,
+		},
+		{
+			name:     "arm",
+			input:    makeELFHeader(elf.ELFCLASS32, elf.ELFDATA2LSB, elf.EM_ARM),
+			expected: "arm",
+			ok:       true,
+		},
+		{
+			name:     "ppc64le",
+			input:    makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_PPC64),
+			expected: "ppc64le",
+			ok:       true,
+		},
+		{
+			name:     "s390x",
+			input:    makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2MSB, elf.EM_S390),
+			expected: "s390x",
+			ok:       true,
+		},
+		{
+			name:     "mips64le",
+			input:    makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_MIPS),
+			expected: "mips64le",
+			ok:       true,
+		},
+		{
+			name:  "unknown",
+			input: makeELFHeader(elf.ELFCLASS32, elf.ELFDATA2MSB, elf.EM_SPARC),
+			ok:    false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, ok := elfArchitecture(testCase.input)
+			if ok != testCase.ok || got != testCase.expected {
+				t.Errorf("got != want: %s, %v != %s, %v", got, ok, testCase.expected, testCase.ok)
+			}
+		})
+	}
+}
+
+type layerFile struct {
+	name    string
+	content []byte
+}
+
+func makeLayer(t *testing.T, files []layerFile) registryv1.Layer {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0755, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := writer.Write(file.content); err != nil {
+			t.Fatalf("Failed to write tar content: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+
+	return layer
+}
+
+func TestEmptyArchitecture(t *testing.T) {
+	script := layerFile{name: "bin/script", content: []byte("#!/bin/sh\necho hello world\n")}
+	binary := layerFile{name: "bin/binary", content: makeELFHeader(elf.ELFCLASS64, elf.ELFDATA2LSB, elf.EM_AARCH64)}
+
+	testCases := []struct {
+		name        string
+		policy      EmptyArchPolicy
+		defaultArch string
+		files       []layerFile
+		expected    []string
+	}{
+		{
+			name:     "unconstrained",
+			policy:   EmptyArchUnconstrained,
+			files:    []layerFile{script, binary},
+			expected: nil,
+		},
+		{
+			name:        "unconstrained-default",
+			policy:      EmptyArchUnconstrained,
+			defaultArch: "amd64",
+			files:       []layerFile{script, binary},
+			expected:    nil,
+		},
+		{
+			name:        "default",
+			policy:      EmptyArchDefault,
+			defaultArch: "amd64",
+			files:       []layerFile{script, binary},
+			expected:    []string{"amd64"},
+		},
+		{
+			name:        "inspect",
+			policy:      EmptyArchInspect,
+			defaultArch: "amd64",
+			files:       []layerFile{script, binary},
+			expected:    []string{"arm64"},
+		},
+		{
+			name:        "inspect-default",
+			policy:      EmptyArchInspect,
+			defaultArch: "amd64",
+			files:       []layerFile{script},
+			expected:    []string{"amd64"},
+		},
+		{
+			name:     "inspect-unconstrained",
+			policy:   EmptyArchInspect,
+			files:    []layerFile{script},
+			expected: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			host := test.UseLocalRegistry(t)
+			ref := fmt.Sprintf("%s/org/image:latest", host)
+
+			image, err := mutate.AppendLayers(empty.Image, makeLayer(t, testCase.files))
+			if err != nil {
+				t.Fatalf("Failed to create image: %v", err)
+			}
+			test.PushImage(t, ref, image)
+
+			EmptyArchitecture = testCase.policy
+			DefaultArchitecture = testCase.defaultArch
+			defer func() {
+				EmptyArchitecture = EmptyArchUnconstrained
+				DefaultArchitecture = ""
+			}()
+
+			ctx := warnings.NewContext(context.Background())
+			resolved, err := containerArchitectures(ctx, ref)
+			if err != nil {
+				t.Fatalf("Failed to get container architectures: %v", err)
+			}
+			arches := resolved.Architectures
+
+			if testCase.expected == nil {
+				if arches != nil {
+					t.Errorf("Expected unconstrained image, got %v", arches)
+				}
+			} else {
+				got := util.Keys(arches)
+				slices.Sort(got)
+				if !slices.Equal(got, testCase.expected) {
+					t.Errorf("got != want: %v != %v", got, testCase.expected)
+				}
+			}
+
+			if len(warnings.FromContext(ctx)) == 0 {
+				t.Errorf("Expected a warning about the missing architecture")
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations naming the entries of an OCI image layout. The OCI one often
+// is only a tag, containerd records the full reference in its own.
+const (
+	refNameAnnotation   = "org.opencontainers.image.ref.name"
+	imageNameAnnotation = "io.containerd.image.name"
+)
+
+// Layout resolves images from an OCI image layout on disk, e.g. for
+// air-gapped clusters without a reachable registry. Entries are matched by
+// their reference name annotation, or by digest for pinned images. Images
+// that aren't in the layout are passed on.
+type Layout struct {
+	path string
+	// repository of the entries named only by a tag.
+	repository string
+
+	mu       sync.RWMutex
+	root     registryv1.ImageIndex
+	manifest *registryv1.IndexManifest
+	modTime  time.Time
+}
+
+// LoadLayout reads the index of the OCI image layout at path. Entries whose
+// reference name is only a tag are taken to be in repository, if it's set.
+func LoadLayout(ctx context.Context, path, repository string) (*Layout, error) {
+	l := &Layout{path: path}
+	if repository != "" {
+		repo, err := regname.NewRepository(repository)
+		if err != nil {
+			return nil, fmt.Errorf("parse repository: %w", err)
+		}
+		l.repository = repo.Name()
+	}
+
+	if _, err := l.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reload reads the index of the layout again if it changed. It returns whether
+// it did. If the index is broken, the previous one is kept.
+func (l *Layout) Reload(ctx context.Context) (bool, error) {
+	_, span := otel.Tracer("").Start(ctx, "Layout.Reload", trace.WithAttributes(attribute.String("path", l.path)))
+	defer span.End()
+
+	info, err := os.Stat(filepath.Join(l.path, "index.json"))
+	if err != nil {
+		return false, fmt.Errorf("stat layout index: %w", err)
+	}
+
+	l.mu.RLock()
+	unchanged := info.ModTime().Equal(l.modTime)
+	l.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	path, err := layout.FromPath(l.path)
+	if err != nil {
+		return false, fmt.Errorf("open layout: %w", err)
+	}
+	root, err := path.ImageIndex()
+	if err != nil {
+		return false, fmt.Errorf("get layout index: %w", err)
+	}
+	manifest, err := root.IndexManifest()
+	if err != nil {
+		return false, fmt.Errorf("get layout index manifest: %w", err)
+	}
+	span.SetAttributes(attribute.Int("manifests", len(manifest.Manifests)))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.root = root
+	l.manifest = manifest
+	l.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// ReloadEvery checks the index of the layout for changes every interval until
+// ctx is done.
+func (l *Layout) ReloadEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := l.Reload(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to reload OCI image layout, keeping the previous index", "path", l.path, "err", err)
+			} else if reloaded {
+				slog.InfoContext(ctx, "Reloaded OCI image layout", "path", l.path)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// layoutHead checks that the blobs of the index' manifests are in the layout.
+func layoutHead(path layout.Path, manifest *registryv1.IndexManifest) headFunc {
+	return func(_ context.Context, digest registryv1.Hash) (types.MediaType, error) {
+		blob, err := path.Blob(digest)
+		if err != nil {
+			return "", err
+		}
+		blob.Close()
+
+		for _, desc := range manifest.Manifests {
+			if desc.Digest == digest {
+				return desc.MediaType, nil
+			}
+		}
+		return "", fmt.Errorf("%s is not in the index", digest)
+	}
+}
+
+// find returns the entry of the layout's index for ref. Entries named only by
+// a tag match tags of ref in repository.
+func find(manifest *registryv1.IndexManifest, ref regname.Reference, refString, repository string) (registryv1.Descriptor, bool) {
+	names := map[string]bool{refString: true, ref.Name(): true}
+	if tag, ok := ref.(regname.Tag); ok && repository != "" && tag.Context().Name() == repository {
+		names[tag.TagStr()] = true
+	}
+	digest, pinned := ref.(regname.Digest)
+
+	for _, desc := range manifest.Manifests {
+		if pinned && desc.Digest.String() == digest.DigestStr() {
+			return desc, true
+		}
+		if names[desc.Annotations[refNameAnnotation]] || names[desc.Annotations[imageNameAnnotation]] {
+			return desc, true
+		}
+	}
+
+	return registryv1.Descriptor{}, false
+}
+
+func (l *Layout) Resolve(ctx context.Context, refString string, _ *corev1.Pod) (*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "Layout.Resolve", trace.WithAttributes(
+		attribute.String("container", refString),
+		attribute.String("path", l.path),
+	))
+	defer span.End()
+
+	ref, err := regname.ParseReference(refString)
+	if err != nil {
+		return nil, fmt.Errorf("parse image reference: %w", err)
+	}
+
+	l.mu.RLock()
+	root, manifest := l.root, l.manifest
+	l.mu.RUnlock()
+
+	desc, ok := find(manifest, ref, refString, l.repository)
+	if !ok {
+		return nil, nil
+	}
+	span.SetAttributes(attribute.String("digest", desc.Digest.String()))
+
+	if len(SignatureKeys) > 0 {
+		return nil, fmt.Errorf("%w: signatures can't be verified from an OCI image layout", ErrUnsigned)
+	}
+
+	if !desc.MediaType.IsIndex() {
+		image, err := root.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("get image: %w", err)
+		}
+		config, err := image.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("get imageConfig: %w", err)
+		}
+
+		if config.Architecture == "" {
+			arches, _ := emptyArchitecture(ctx, refString, image)
+			return &Image{Reference: refString, Digest: desc.Digest.String(), Architectures: arches, Source: SourceLayout, ResolvedAt: time.Now()}, nil
+		}
+
+		arches, reported := normalizedArchitectures(ctx, refString, []string{platformArchitecture(config.Architecture, config.Variant)})
+		return &Image{
+			Reference:     refString,
+			Digest:        desc.Digest.String(),
+			Architectures: arches,
+			Source:        SourceLayout,
+			ResolvedAt:    time.Now(),
+			Reported:      reported,
+		}, nil
+	}
+
+	index, err := root.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("get index: %w", err)
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("get index manifest: %w", err)
+	}
+
+	platforms := []registryv1.Descriptor{}
+	for _, image := range indexManifest.Manifests {
+		if image.Platform != nil {
+			platforms = append(platforms, image)
+		}
+	}
+
+	reported := []string{}
+	for _, image := range verifiedPlatforms(ctx, refString, layoutHead(layout.Path(l.path), indexManifest), index, platforms) {
+		reported = append(reported, platformArchitecture(image.Platform.Architecture, image.Platform.Variant))
+	}
+	aggregator, raw := normalizedArchitectures(ctx, refString, reported)
+
+	return &Image{Reference: refString, Digest: desc.Digest.String(), Architectures: aggregator, Source: SourceLayout, ResolvedAt: time.Now(), Reported: raw}, nil
+}
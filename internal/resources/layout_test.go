@@ -0,0 +1,171 @@
+package resources
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+)
+
+func TestLayout(t *testing.T) {
+	amd64 := registryv1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := registryv1.Platform{OS: "linux", Architecture: "arm64"}
+
+	path := test.NewLayout(t)
+	indexDigest := test.LayoutIndex(t, path, "registry.local/org/multi:1.0", []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Missing: true}})
+	test.LayoutImage(t, path, "index.docker.io/library/single:1.0", test.PlatformConfig(t, arm64))
+	test.LayoutImage(t, path, "2.0", test.PlatformConfig(t, amd64))
+
+	resolver, err := LoadLayout(context.Background(), string(path), "registry.local/org/tagged")
+	if err != nil {
+		t.Fatalf("Failed to load layout: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		mode     VerifyMode
+		expected map[string]bool
+		missing  bool
+	}{
+		{
+			name:     "index",
+			input:    "registry.local/org/multi:1.0",
+			mode:     VerifyNone,
+			expected: map[string]bool{"amd64": true, "arm64": true},
+		},
+		{
+			name:     "verified",
+			input:    "registry.local/org/multi:1.0",
+			mode:     VerifyManifest,
+			expected: map[string]bool{"amd64": true},
+		},
+		{
+			name:     "normalized",
+			input:    "single:1.0",
+			mode:     VerifyNone,
+			expected: map[string]bool{"arm64": true},
+		},
+		{
+			name:     "digest",
+			input:    "registry.local/org/other@" + indexDigest.String(),
+			mode:     VerifyNone,
+			expected: map[string]bool{"amd64": true, "arm64": true},
+		},
+		{
+			name:     "tag",
+			input:    "registry.local/org/tagged:2.0",
+			mode:     VerifyNone,
+			expected: map[string]bool{"amd64": true},
+		},
+		{
+			name:    "missing",
+			input:   "registry.local/org/multi:2.0",
+			mode:    VerifyNone,
+			missing: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			PlatformVerification = testCase.mode
+			defer func() { PlatformVerification = VerifyNone }()
+
+			image, err := resolver.Resolve(context.Background(), testCase.input, nil)
+			if err != nil {
+				t.Fatalf("Failed to resolve: %v", err)
+			}
+			if testCase.missing {
+				if image != nil {
+					t.Errorf("Expected image to be passed on, got: %v", image)
+				}
+				return
+			}
+
+			if image == nil {
+				t.Fatalf("Expected image to be in the layout")
+			}
+			if image.Source != SourceLayout {
+				t.Errorf("got != want: %s != %s", image.Source, SourceLayout)
+			}
+			if !reflect.DeepEqual(image.Architectures, testCase.expected) {
+				t.Errorf("got != want: %v != %v", image.Architectures, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestLayoutUnsigned(t *testing.T) {
+	path := test.NewLayout(t)
+	test.LayoutImage(t, path, "registry.local/image:1.0", test.PlatformConfig(t, registryv1.Platform{OS: "linux", Architecture: "amd64"}))
+
+	SignatureKeys = []crypto.PublicKey{nil}
+	defer func() { SignatureKeys = []crypto.PublicKey{} }()
+
+	resolver, err := LoadLayout(context.Background(), string(path), "")
+	if err != nil {
+		t.Fatalf("Failed to load layout: %v", err)
+	}
+
+	_, err = resolver.Resolve(context.Background(), "registry.local/image:1.0", nil)
+	if !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected images from a layout to be unsigned, got: %v", err)
+	}
+}
+
+func TestLayoutReload(t *testing.T) {
+	ctx := context.Background()
+	path := test.NewLayout(t)
+
+	resolver, err := LoadLayout(ctx, string(path), "")
+	if err != nil {
+		t.Fatalf("Failed to load layout: %v", err)
+	}
+
+	test.LayoutImage(t, path, "registry.local/image:1.0", test.PlatformConfig(t, registryv1.Platform{OS: "linux", Architecture: "amd64"}))
+	// The index may be written within the resolution of the mtime.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(string(path), "index.json"), later, later); err != nil {
+		t.Fatalf("Failed to touch index: %v", err)
+	}
+
+	image, err := resolver.Resolve(ctx, "registry.local/image:1.0", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if image != nil {
+		t.Errorf("Expected the index to be cached, got: %v", image)
+	}
+
+	reloaded, err := resolver.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Failed to reload layout: %v", err)
+	}
+	if !reloaded {
+		t.Errorf("Expected the changed index to be reloaded")
+	}
+
+	image, err = resolver.Resolve(ctx, "registry.local/image:1.0", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if image == nil {
+		t.Fatalf("Expected image to be in the reloaded layout")
+	}
+
+	reloaded, err = resolver.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Failed to reload layout: %v", err)
+	}
+	if reloaded {
+		t.Errorf("Expected the unchanged index to be kept")
+	}
+}
@@ -0,0 +1,109 @@
+package resources
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// architectureAliases maps the names registries and build tools report for
+// architectures to the GOARCH names nodes are labeled with, and the variant
+// they imply.
+var architectureAliases = map[string]struct{ arch, variant string }{
+	"aarch64":     {"arm64", ""},
+	"arm64":       {"arm64", ""},
+	"armv8":       {"arm64", ""},
+	"armv8l":      {"arm64", ""},
+	"x86_64":      {"amd64", ""},
+	"x86-64":      {"amd64", ""},
+	"x64":         {"amd64", ""},
+	"amd64":       {"amd64", ""},
+	"i386":        {"386", ""},
+	"i486":        {"386", ""},
+	"i586":        {"386", ""},
+	"i686":        {"386", ""},
+	"x86":         {"386", ""},
+	"386":         {"386", ""},
+	"armhf":       {"arm", "v7"},
+	"armv7":       {"arm", "v7"},
+	"armv7l":      {"arm", "v7"},
+	"armel":       {"arm", "v6"},
+	"armv6":       {"arm", "v6"},
+	"armv6l":      {"arm", "v6"},
+	"armv5":       {"arm", "v5"},
+	"armv5l":      {"arm", "v5"},
+	"ppc64el":     {"ppc64le", ""},
+	"powerpc64le": {"ppc64le", ""},
+}
+
+// NormalizeArchitecture returns the GOARCH name and the canonical variant of
+// an architecture as reported by a registry. The architecture may include the
+// variant, e.g. "arm64/v8". Unknown architectures are only lowercased.
+func NormalizeArchitecture(arch, variant string) (string, string) {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	variant = strings.ToLower(strings.TrimSpace(variant))
+	if before, after, ok := strings.Cut(arch, "/"); ok {
+		arch = before
+		if variant == "" {
+			variant = after
+		}
+	}
+
+	if alias, ok := architectureAliases[arch]; ok {
+		arch = alias.arch
+		if variant == "" {
+			variant = alias.variant
+		}
+	}
+
+	// Variants that are the default of their architecture are omitted, like
+	// the OCI image spec does.
+	switch {
+	case arch == "arm64" && (variant == "v8" || variant == "8"):
+		variant = ""
+	case arch == "amd64" && variant == "v1":
+		variant = ""
+	case arch == "arm" && len(variant) == 1:
+		variant = "v" + variant
+	}
+
+	return arch, variant
+}
+
+// platformArchitecture returns the architecture and variant as one string,
+// like registries report them.
+func platformArchitecture(arch, variant string) string {
+	if variant == "" {
+		return arch
+	}
+
+	return arch + "/" + variant
+}
+
+// normalizedArchitectures returns the set of GOARCH names of the reported
+// architectures, and the reported ones if normalizing changed any of them.
+// Reported architectures may include the variant, e.g. "arm/v7".
+func normalizedArchitectures(ctx context.Context, refString string, reported []string) (map[string]bool, []string) {
+	ret := map[string]bool{}
+	changed := false
+	for _, platform := range reported {
+		arch, variant := NormalizeArchitecture(platform, "")
+		// Variants aren't part of node labels.
+		ret[arch] = true
+		changed = changed || platformArchitecture(arch, variant) != platform
+	}
+	if !changed {
+		return ret, nil
+	}
+
+	raw := slices.Clone(reported)
+	slices.Sort(raw)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.StringSlice("reported", raw))
+	slog.DebugContext(ctx, "Normalized architectures", "image", refString, "reported", raw)
+
+	return ret, raw
+}
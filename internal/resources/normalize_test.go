@@ -0,0 +1,110 @@
+package resources
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+)
+
+func TestNormalizeArchitecture(t *testing.T) {
+	testCases := []struct {
+		arch    string
+		variant string
+		want    string
+	}{
+		{arch: "amd64", want: "amd64"},
+		{arch: "x86_64", want: "amd64"},
+		{arch: "X86-64", want: "amd64"},
+		{arch: "amd64", variant: "v1", want: "amd64"},
+		{arch: "amd64", variant: "v3", want: "amd64/v3"},
+		{arch: "aarch64", want: "arm64"},
+		{arch: "arm64", variant: "v8", want: "arm64"},
+		{arch: "arm64/v8", want: "arm64"},
+		{arch: "arm64", variant: "8", want: "arm64"},
+		{arch: "i386", want: "386"},
+		{arch: "i686", want: "386"},
+		{arch: "armhf", want: "arm/v7"},
+		{arch: "armv7l", want: "arm/v7"},
+		{arch: "armel", want: "arm/v6"},
+		{arch: "arm", variant: "7", want: "arm/v7"},
+		{arch: "arm/v6", want: "arm/v6"},
+		{arch: "arm", want: "arm"},
+		{arch: "ppc64el", want: "ppc64le"},
+		{arch: " RISCV64 ", want: "riscv64"},
+		{arch: "wasm", want: "wasm"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(platformArchitecture(testCase.arch, testCase.variant), func(t *testing.T) {
+			arch, variant := NormalizeArchitecture(testCase.arch, testCase.variant)
+			if got := platformArchitecture(arch, variant); got != testCase.want {
+				t.Errorf("got != want: %s != %s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestNormalizedArchitectures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []string
+		expected map[string]bool
+		reported []string
+	}{
+		{
+			name:     "canonical",
+			input:    []string{"arm/v7", "amd64"},
+			expected: map[string]bool{"arm": true, "amd64": true},
+		},
+		{
+			name:     "aliases",
+			input:    []string{"x86_64", "aarch64", "arm64"},
+			expected: map[string]bool{"amd64": true, "arm64": true},
+			reported: []string{"aarch64", "arm64", "x86_64"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got, reported := normalizedArchitectures(context.Background(), "image", testCase.input)
+			if !reflect.DeepEqual(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+			if !reflect.DeepEqual(reported, testCase.reported) {
+				t.Errorf("got != want: %v != %v", reported, testCase.reported)
+			}
+		})
+	}
+}
+
+func TestNormalizeIndex(t *testing.T) {
+	path := test.NewLayout(t)
+	test.LayoutIndex(t, path, "registry.local/org/legacy:1.0", []test.PlatformImage{
+		{Platform: registryv1.Platform{OS: "linux", Architecture: "x86_64"}},
+		{Platform: registryv1.Platform{OS: "linux", Architecture: "aarch64"}},
+		{Platform: registryv1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+	})
+
+	resolver, err := LoadLayout(context.Background(), string(path), "")
+	if err != nil {
+		t.Fatalf("Failed to load layout: %v", err)
+	}
+
+	image, err := resolver.Resolve(context.Background(), "registry.local/org/legacy:1.0", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve image: %v", err)
+	}
+
+	want := map[string]bool{"amd64": true, "arm64": true, "arm": true}
+	if !reflect.DeepEqual(image.Architectures, want) {
+		t.Errorf("got != want: %v != %v", image.Architectures, want)
+	}
+	wantReported := []string{"aarch64", "arm/v7", "x86_64"}
+	if !reflect.DeepEqual(image.Reported, wantReported) {
+		t.Errorf("got != want: %v != %v", image.Reported, wantReported)
+	}
+}
@@ -0,0 +1,217 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// Override pins the architectures of the images matching a pattern, for
+// images with wrong platform metadata or from registries that can't be asked.
+type Override struct {
+	// Image is a glob (as in path.Match) for the reference, either as written
+	// in the pod or normalized, e.g. "index.docker.io/library/nginx:1.25".
+	Image string `json:"image,omitempty"`
+	// Regex is matched against the same references as Image.
+	Regex string `json:"regex,omitempty"`
+	// Platforms are the architectures the images run on.
+	Platforms []string `json:"platforms,omitempty"`
+	// Unconstrained images run on any architecture.
+	Unconstrained bool `json:"unconstrained,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// overrideFile is the format of the override file.
+type overrideFile struct {
+	Overrides []Override `json:"overrides"`
+}
+
+func (o *Override) pattern() string {
+	if o.Regex != "" {
+		return o.Regex
+	}
+	return o.Image
+}
+
+func (o *Override) compile() error {
+	if (o.Image == "") == (o.Regex == "") {
+		return fmt.Errorf("exactly one of image or regex is required")
+	}
+	if (len(o.Platforms) == 0) == !o.Unconstrained {
+		return fmt.Errorf("%s: exactly one of platforms or unconstrained is required", o.pattern())
+	}
+
+	if o.Regex != "" {
+		regex, err := regexp.Compile(o.Regex)
+		if err != nil {
+			return fmt.Errorf("compile regex: %w", err)
+		}
+		o.regex = regex
+		return nil
+	}
+
+	if _, err := path.Match(o.Image, ""); err != nil {
+		return fmt.Errorf("%s: %w", o.Image, err)
+	}
+	return nil
+}
+
+func (o *Override) matches(refs []string) bool {
+	for _, ref := range refs {
+		if o.regex != nil {
+			if o.regex.MatchString(ref) {
+				return true
+			}
+			continue
+		}
+
+		if ok, _ := path.Match(o.Image, ref); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseOverrides reads the overrides from YAML (or JSON) content.
+func ParseOverrides(content []byte) ([]Override, error) {
+	file := overrideFile{}
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("decode overrides: %w", err)
+	}
+
+	for i := range file.Overrides {
+		if err := file.Overrides[i].compile(); err != nil {
+			return nil, fmt.Errorf("override %d: %w", i, err)
+		}
+	}
+
+	return file.Overrides, nil
+}
+
+// Overrides resolves images from an override file. It comes first in a Chain,
+// the first matching override wins and images without one are passed on.
+type Overrides struct {
+	path string
+
+	mu        sync.RWMutex
+	overrides []Override
+	modTime   time.Time
+}
+
+// LoadOverrides reads the override file at path.
+func LoadOverrides(ctx context.Context, path string) (*Overrides, error) {
+	overrides := &Overrides{path: path}
+	if _, err := overrides.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// Reload reads the override file again if it changed. It returns whether it
+// did. If the file is broken, the previous overrides are kept.
+func (o *Overrides) Reload(ctx context.Context) (bool, error) {
+	_, span := otel.Tracer("").Start(ctx, "Overrides.Reload", trace.WithAttributes(attribute.String("path", o.path)))
+	defer span.End()
+
+	// Stat follows symlinks, so this also notices the updates of mounted
+	// ConfigMaps.
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return false, fmt.Errorf("stat overrides: %w", err)
+	}
+
+	o.mu.RLock()
+	unchanged := info.ModTime().Equal(o.modTime)
+	o.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(o.path)
+	if err != nil {
+		return false, fmt.Errorf("read overrides: %w", err)
+	}
+	overrides, err := ParseOverrides(content)
+	if err != nil {
+		return false, err
+	}
+	span.SetAttributes(attribute.Int("overrides", len(overrides)))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides = overrides
+	o.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// ReloadEvery checks the override file for changes every interval until ctx
+// is done.
+func (o *Overrides) ReloadEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := o.Reload(ctx)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to reload overrides, keeping the previous ones", "path", o.path, "err", err)
+			} else if reloaded {
+				slog.InfoContext(ctx, "Reloaded overrides", "path", o.path)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *Overrides) Resolve(ctx context.Context, refString string, _ *corev1.Pod) (*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "Overrides.Resolve", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	refs := []string{refString}
+	if ref, err := regname.ParseReference(refString); err == nil {
+		refs = append(refs, ref.Name())
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	for i := range o.overrides {
+		override := &o.overrides[i]
+		if !override.matches(refs) {
+			continue
+		}
+
+		span.SetAttributes(attribute.String("override", override.pattern()))
+		image := &Image{Reference: refString, Source: SourceOverride, Override: override.pattern(), ResolvedAt: time.Now()}
+		if override.Unconstrained {
+			warnings.Add(ctx, "image %s matches override %s, not constraining its platforms", refString, override.pattern())
+			return image, nil
+		}
+
+		image.Architectures, image.Reported = normalizedArchitectures(ctx, refString, override.Platforms)
+		warnings.Add(ctx, "image %s matches override %s, using platforms %v", refString, override.pattern(), override.Platforms)
+		return image, nil
+	}
+
+	return nil, nil
+}
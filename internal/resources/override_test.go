@@ -0,0 +1,203 @@
+package resources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+const testOverrides = `
+overrides:
+- image: registry.local/broken:*
+  platforms: [amd64]
+- regex: ^index\.docker\.io/library/nginx:.*$
+  platforms: [arm64, amd64]
+- image: registry.internal/*
+  unconstrained: true
+`
+
+func TestParseOverrides(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		count int
+		err   bool
+	}{
+		{
+			name:  "valid",
+			input: testOverrides,
+			count: 3,
+		},
+		{
+			name:  "empty",
+			input: `overrides: []`,
+			count: 0,
+		},
+		{
+			name:  "both-patterns",
+			input: `{"overrides": [{"image": "image", "regex": "image", "platforms": ["amd64"]}]}`,
+			err:   true,
+		},
+		{
+			name:  "no-platforms",
+			input: `{"overrides": [{"image": "image"}]}`,
+			err:   true,
+		},
+		{
+			name:  "both-platforms",
+			input: `{"overrides": [{"image": "image", "platforms": ["amd64"], "unconstrained": true}]}`,
+			err:   true,
+		},
+		{
+			name:  "regex",
+			input: `{"overrides": [{"regex": "(", "platforms": ["amd64"]}]}`,
+			err:   true,
+		},
+		{
+			name:  "glob",
+			input: `{"overrides": [{"image": "[", "platforms": ["amd64"]}]}`,
+			err:   true,
+		},
+		{
+			name:  "unknown",
+			input: `{"overrides": [{"image": "image", "platform": ["amd64"]}]}`,
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			overrides, err := ParseOverrides([]byte(testCase.input))
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error, got: %v", overrides)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse overrides: %v", err)
+			}
+
+			if len(overrides) != testCase.count {
+				t.Errorf("got != want: %d != %d overrides", len(overrides), testCase.count)
+			}
+		})
+	}
+}
+
+func TestOverridesResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	if err := os.WriteFile(path, []byte(testOverrides), 0o644); err != nil {
+		t.Fatalf("Failed to write overrides: %v", err)
+	}
+
+	overrides, err := LoadOverrides(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Failed to load overrides: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected map[string]bool
+		override string
+	}{
+		{
+			name:     "glob",
+			input:    "registry.local/broken:1.0",
+			expected: map[string]bool{"amd64": true},
+			override: "registry.local/broken:*",
+		},
+		{
+			name:     "normalized",
+			input:    "nginx:1.25",
+			expected: map[string]bool{"amd64": true, "arm64": true},
+			override: `^index\.docker\.io/library/nginx:.*$`,
+		},
+		{
+			name:     "unconstrained",
+			input:    "registry.internal/image",
+			override: "registry.internal/*",
+		},
+		{
+			name:  "unmatched",
+			input: "registry.local/org/image:1.0",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := warnings.NewContext(context.Background())
+
+			image, err := overrides.Resolve(ctx, testCase.input, nil)
+			if err != nil {
+				t.Fatalf("Failed to resolve: %v", err)
+			}
+			if testCase.override == "" {
+				if image != nil {
+					t.Errorf("Expected no override, got: %v", image)
+				}
+				return
+			}
+
+			if image == nil {
+				t.Fatalf("Expected override %s to match", testCase.override)
+			}
+			if image.Override != testCase.override || image.Source != SourceOverride {
+				t.Errorf("got != want: %s (%s) != %s", image.Override, image.Source, testCase.override)
+			}
+			if !reflect.DeepEqual(image.Architectures, testCase.expected) {
+				t.Errorf("got != want: %v != %v", image.Architectures, testCase.expected)
+			}
+			if len(warnings.FromContext(ctx)) != 1 {
+				t.Errorf("Expected a warning, got: %v", warnings.FromContext(ctx))
+			}
+		})
+	}
+}
+
+func TestOverridesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write overrides: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	now := time.Now()
+	write(`{"overrides": [{"image": "image:*", "platforms": ["amd64"]}]}`, now)
+	overrides, err := LoadOverrides(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Failed to load overrides: %v", err)
+	}
+
+	if reloaded, err := overrides.Reload(context.Background()); reloaded || err != nil {
+		t.Errorf("Expected unchanged file to be skipped, got: %v, %v", reloaded, err)
+	}
+
+	write(`{"overrides": [{"image": "image:*", "platforms": ["arm64"]}]}`, now.Add(time.Second))
+	if reloaded, err := overrides.Reload(context.Background()); !reloaded || err != nil {
+		t.Fatalf("Expected changed file to be reloaded, got: %v, %v", reloaded, err)
+	}
+	image, _ := overrides.Resolve(context.Background(), "image:1.0", nil)
+	if !image.Architectures["arm64"] {
+		t.Errorf("Expected reloaded override, got: %v", image)
+	}
+
+	write(`{"overrides": [{"image": "image:*"}]}`, now.Add(2*time.Second))
+	if _, err := overrides.Reload(context.Background()); err == nil {
+		t.Errorf("Expected broken file to fail")
+	}
+	image, _ = overrides.Resolve(context.Background(), "image:1.0", nil)
+	if !image.Architectures["arm64"] {
+		t.Errorf("Expected previous override to be kept, got: %v", image)
+	}
+}
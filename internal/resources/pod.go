@@ -3,83 +3,177 @@ package resources
 import (
 	"context"
 	"fmt"
+	"time"
 
 	regname "github.com/google/go-containerregistry/pkg/name"
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
 	registry "github.com/google/go-containerregistry/pkg/v1/remote"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/ongy/k8s-auto-arch/internal/util"
 )
 
-var (
-	// Indirection for testing!
-	doContainerArchitectures = containerArchitectures
-)
+// Image is what was learned about an image reference.
+type Image struct {
+	// Reference as written in the pod spec.
+	Reference string
+	// Digest of the index or manifest the architectures were read from.
+	Digest string
+	// Architectures the image can run on. A nil set means the image doesn't
+	// constrain the architecture.
+	Architectures map[string]bool
+	// Source of the architectures, one of the Source constants.
+	Source string
+	// ResolvedAt is when the architectures were resolved.
+	ResolvedAt time.Time
+	// Override is the pattern of the override file that matched the image.
+	Override string
+	// Reported are the architectures as the source reported them, if they
+	// had to be normalized.
+	Reported []string
+	// Warnings the source added while resolving the image. The cache repeats
+	// them for every pod using the image.
+	Warnings []string
+}
 
-func containerArchitectures(ctx context.Context, refString string) (map[string]bool, error) {
+// containerArchitectures resolves the architectures the image can run on.
+func containerArchitectures(ctx context.Context, refString string) (*Image, error) {
 	ctx, span := otel.Tracer("").Start(ctx, "containerArchitectures", trace.WithAttributes(attribute.String("container", refString)))
 	defer span.End()
 
 	ref, err := regname.ParseReference(refString)
 	if err != nil {
-		return map[string]bool{}, fmt.Errorf("parse image reference: %w", err)
+		return nil, fmt.Errorf("parse image reference: %w", err)
 	}
-	index, err := registry.Index(ref)
+	index, err := registry.Index(ref, registry.WithContext(ctx))
 	if err != nil {
-		image, err := registry.Image(ref)
+		image, err := registry.Image(ref, registry.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("get image: %w", err)
+		}
+
+		digest, err := image.Digest()
 		if err != nil {
-			return map[string]bool{}, fmt.Errorf("get image: %w", err)
+			return nil, fmt.Errorf("get image digest: %w", err)
+		}
+		span.SetAttributes(attribute.String("digest", digest.String()))
+
+		if err := verifySignature(ctx, ref.Context(), digest); err != nil {
+			return nil, fmt.Errorf("verify signature: %w", err)
 		}
 
 		imageConfig, err := image.ConfigFile()
 		if err != nil {
-			return map[string]bool{}, fmt.Errorf("get imageConfig: %w", err)
+			return nil, fmt.Errorf("get imageConfig: %w", err)
+		}
+
+		if imageConfig.Architecture == "" {
+			arches, source := emptyArchitecture(ctx, refString, image)
+			span.SetAttributes(attribute.String("source", source))
+			return &Image{Reference: refString, Digest: digest.String(), Architectures: arches, Source: source}, nil
 		}
 
-		return map[string]bool{imageConfig.Architecture: true}, nil
+		span.SetAttributes(attribute.String("source", SourceConfig))
+		arches, reported := normalizedArchitectures(ctx, refString, []string{platformArchitecture(imageConfig.Architecture, imageConfig.Variant)})
+		return &Image{
+			Reference:     refString,
+			Digest:        digest.String(),
+			Architectures: arches,
+			Source:        SourceConfig,
+			Reported:      reported,
+		}, nil
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("get index digest: %w", err)
+	}
+	span.SetAttributes(attribute.String("digest", digest.String()))
+
+	if err := verifySignature(ctx, ref.Context(), digest); err != nil {
+		return nil, fmt.Errorf("verify signature: %w", err)
 	}
 
 	manifest, err := index.IndexManifest()
 	if err != nil {
-		return map[string]bool{}, fmt.Errorf("get index manifest: %w", err)
+		return nil, fmt.Errorf("get index manifest: %w", err)
 	}
 
-	//TODO: Solve for OS as well!
-	aggregator := map[string]bool{}
+	platforms := []registryv1.Descriptor{}
 	for _, image := range manifest.Manifests {
-		aggregator[image.Platform.Architecture] = true
+		if image.Platform != nil {
+			platforms = append(platforms, image)
+		}
 	}
 
-	return aggregator, nil
+	//TODO: Solve for OS as well!
+	reported := []string{}
+	for _, image := range verifiedPlatforms(ctx, refString, registryHead(ref.Context()), index, platforms) {
+		reported = append(reported, platformArchitecture(image.Platform.Architecture, image.Platform.Variant))
+	}
+	aggregator, raw := normalizedArchitectures(ctx, refString, reported)
+	span.SetAttributes(attribute.String("source", SourceIndex))
+
+	return &Image{Reference: refString, Digest: digest.String(), Architectures: aggregator, Source: SourceIndex, Reported: raw}, nil
 }
 
-func Architectures(ctx context.Context, pod *corev1.Pod) ([]string, error) {
+// Architectures returns the architectures all containers of the pod can run
+// on, and the images they were resolved from keyed by their reference. The
+// architectures are nil if none of the images constrains the architecture.
+func Architectures(ctx context.Context, resolver Resolver, pod *corev1.Pod) ([]string, map[string]*Image, error) {
 	ctx, span := otel.Tracer("").Start(ctx, "Architectures")
 	defer span.End()
 
+	images := map[string]*Image{}
 	var podArches map[string]bool
-	for _, container := range pod.Spec.Containers {
-		arches, err := doContainerArchitectures(ctx, container.Image)
-		if err != nil {
-			return []string{}, fmt.Errorf("get arches of container '%s': %w", container.Name, err)
+	resolve := func(kind, name, ref string) error {
+		image, ok := images[ref]
+		if !ok {
+			var err error
+			image, err = resolver.Resolve(ctx, ref, pod)
+			if err != nil {
+				return fmt.Errorf("get arches of %s '%s': %w", kind, name, err)
+			}
+			if image == nil {
+				return fmt.Errorf("get arches of %s '%s': no resolver knows image %s", kind, name, ref)
+			}
+			images[ref] = image
 		}
 
-		podArches = util.Intersect(podArches, arches)
+		if image.Architectures != nil {
+			podArches = util.Intersect(podArches, image.Architectures)
+		}
+		return nil
+	}
+
+	for _, container := range pod.Spec.Containers {
+		if err := resolve("container", container.Name, container.Image); err != nil {
+			return []string{}, nil, err
+		}
 	}
 
 	for _, container := range pod.Spec.InitContainers {
-		arches, err := doContainerArchitectures(ctx, container.Image)
-		if err != nil {
-			return []string{}, fmt.Errorf("get arches of initContainer '%s': %w", container.Name, err)
+		if err := resolve("initContainer", container.Name, container.Image); err != nil {
+			return []string{}, nil, err
 		}
+	}
+
+	for _, container := range pod.Spec.EphemeralContainers {
+		if err := resolve("ephemeralContainer", container.Name, container.Image); err != nil {
+			return []string{}, nil, err
+		}
+	}
 
-		podArches = util.Intersect(podArches, arches)
+	if podArches == nil {
+		return nil, images, nil
 	}
 
 	ret := util.Keys(podArches)
+	slices.Sort(ret)
 	span.SetAttributes(attribute.StringSlice("arches", ret))
-	return ret, nil
+	return ret, images, nil
 }
@@ -39,7 +39,7 @@ func TestContainerArchitectures(t *testing.T) {
 			}
 
 			want := testCase.expected
-			got := util.Keys(arches)
+			got := util.Keys(arches.Architectures)
 			slices.Sort(want)
 			slices.Sort(got)
 
@@ -162,31 +162,76 @@ func TestArchitectures(t *testing.T) {
 			},
 			expected: []string{"amd64"},
 		},
+		{
+			name: "unconstrained",
+			input: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: "image",
+						},
+						{
+							Image: "image2",
+						},
+					},
+				},
+			},
+			arches: map[string][]string{
+				"image":  nil,
+				"image2": {"amd64", "arm64"},
+			},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name: "all-unconstrained",
+			input: v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: "image",
+						},
+					},
+				},
+			},
+			arches: map[string][]string{
+				"image": nil,
+			},
+			expected: nil,
+		},
 	}
 
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			doContainerArchitectures = func(_ context.Context, imgName string) (map[string]bool, error) {
+			resolver := ResolverFunc(func(_ context.Context, imgName string, _ *v1.Pod) (*Image, error) {
 				arches, ok := testCase.arches[imgName]
 				if !ok {
 					return nil, fmt.Errorf("couldn't find container")
 				}
+				if arches == nil {
+					return &Image{Reference: imgName}, nil
+				}
 
 				ret := map[string]bool{}
 				for _, arch := range arches {
 					ret[arch] = true
 				}
 
-				return ret, nil
-			}
+				return &Image{Reference: imgName, Architectures: ret}, nil
+			})
 
 			want := testCase.expected
-			got, err := Architectures(context.Background(), &testCase.input)
+			got, images, err := Architectures(context.Background(), resolver, &testCase.input)
 			if err != nil {
 				t.Errorf("Failed call to Architectures: %v", err)
 				return
 			}
 
+			for _, container := range testCase.input.Spec.Containers {
+				if image, ok := images[container.Image]; !ok || image.Reference != container.Image {
+					t.Errorf("Missing image for container '%s'", container.Image)
+				}
+			}
+
 			slices.Sort(got)
 			slices.Sort(want)
 			if !slices.Equal(got, want) {
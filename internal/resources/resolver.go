@@ -0,0 +1,96 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// Resolver learns which architectures an image runs on.
+type Resolver interface {
+	// Resolve returns the image, or nil if the resolver doesn't know it and
+	// the next resolver of a Chain should be asked. The pod the image is
+	// resolved for may be nil, e.g. when warming a cache.
+	Resolve(ctx context.Context, refString string, pod *corev1.Pod) (*Image, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ctx context.Context, refString string, pod *corev1.Pod) (*Image, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, refString string, pod *corev1.Pod) (*Image, error) {
+	return f(ctx, refString, pod)
+}
+
+// Chain asks its resolvers in order, and returns the first image one of them
+// knows. If a resolver fails, the later ones are still asked, so a fallback at
+// the end can answer for unreachable registries. Unsigned images are never
+// passed on.
+type Chain []Resolver
+
+func (c Chain) Resolve(ctx context.Context, refString string, pod *corev1.Pod) (*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "Chain.Resolve", trace.WithAttributes(attribute.String("container", refString)))
+	defer span.End()
+
+	var failure error
+	for i, resolver := range c {
+		image, err := resolver.Resolve(ctx, refString, pod)
+		if errors.Is(err, ErrUnsigned) {
+			return nil, err
+		}
+		if err != nil {
+			span.RecordError(err)
+			if failure == nil {
+				failure = err
+			}
+			continue
+		}
+		if image == nil {
+			continue
+		}
+
+		span.SetAttributes(attribute.Int("resolver", i), attribute.String("source", image.Source))
+		if failure != nil {
+			warnings.Add(ctx, "failed to resolve image %s, using %s platforms: %v", refString, image.Source, failure)
+		}
+		return image, nil
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+	return nil, fmt.Errorf("no resolver knows image %s", refString)
+}
+
+// Registry resolves images from their registry.
+type Registry struct{}
+
+func (Registry) Resolve(ctx context.Context, refString string, _ *corev1.Pod) (*Image, error) {
+	return containerArchitectures(ctx, refString)
+}
+
+// Fallback resolves every image to the same architectures. At the end of a
+// Chain, it keeps pods with unresolvable images schedulable. Nil architectures
+// don't constrain the pod.
+type Fallback struct {
+	Architectures map[string]bool
+}
+
+func (f *Fallback) Resolve(ctx context.Context, refString string, _ *corev1.Pod) (*Image, error) {
+	image := &Image{Reference: refString, Source: SourceFallback, ResolvedAt: time.Now()}
+	if f.Architectures != nil {
+		image.Architectures = map[string]bool{}
+		for arch := range f.Architectures {
+			image.Architectures[arch] = true
+		}
+	}
+
+	return image, nil
+}
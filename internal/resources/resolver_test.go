@@ -0,0 +1,109 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func staticResolver(source string, err error) Resolver {
+	return ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*Image, error) {
+		if err != nil {
+			return nil, err
+		}
+		if source == "" {
+			return nil, nil
+		}
+		return &Image{Reference: ref, Source: source}, nil
+	})
+}
+
+func TestChain(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chain    Chain
+		source   string
+		err      bool
+		unsigned bool
+		warnings int
+	}{
+		{
+			name:   "first",
+			chain:  Chain{staticResolver(SourceOverride, nil), staticResolver(SourceIndex, nil)},
+			source: SourceOverride,
+		},
+		{
+			name:   "unknown",
+			chain:  Chain{staticResolver("", nil), staticResolver(SourceIndex, nil)},
+			source: SourceIndex,
+		},
+		{
+			name:     "fallback",
+			chain:    Chain{staticResolver("", errors.New("registry down")), &Fallback{}},
+			source:   SourceFallback,
+			warnings: 1,
+		},
+		{
+			name:     "unsigned",
+			chain:    Chain{staticResolver("", fmt.Errorf("verify signature: %w", ErrUnsigned)), &Fallback{}},
+			err:      true,
+			unsigned: true,
+		},
+		{
+			name:  "failed",
+			chain: Chain{staticResolver("", errors.New("registry down")), staticResolver("", nil)},
+			err:   true,
+		},
+		{
+			name:  "empty",
+			chain: Chain{staticResolver("", nil)},
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := warnings.NewContext(context.Background())
+
+			image, err := testCase.chain.Resolve(ctx, "image:1.0", nil)
+			if testCase.err {
+				if err == nil {
+					t.Fatalf("Expected an error, got: %v", image)
+				}
+				if errors.Is(err, ErrUnsigned) != testCase.unsigned {
+					t.Errorf("got != want: %v != %v unsigned", errors.Is(err, ErrUnsigned), testCase.unsigned)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to resolve: %v", err)
+			}
+
+			if image.Source != testCase.source {
+				t.Errorf("got != want: %s != %s", image.Source, testCase.source)
+			}
+			if got := len(warnings.FromContext(ctx)); got != testCase.warnings {
+				t.Errorf("got != want: %d != %d warnings", got, testCase.warnings)
+			}
+		})
+	}
+}
+
+func TestFallback(t *testing.T) {
+	fallback := &Fallback{Architectures: map[string]bool{"amd64": true}}
+
+	image, err := fallback.Resolve(context.Background(), "image:1.0", nil)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	image.Architectures["arm64"] = true
+
+	if len(fallback.Architectures) != 1 {
+		t.Errorf("Expected the fallback architectures to be copied, got: %v", fallback.Architectures)
+	}
+}
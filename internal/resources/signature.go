@@ -0,0 +1,194 @@
+package resources
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	registry "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignSignatureType       = "cosign container image signature"
+)
+
+var (
+	// SignatureKeys are trusted to sign images. Signatures aren't checked if
+	// there are none.
+	SignatureKeys = []crypto.PublicKey{}
+
+	// ErrUnsigned is returned for images without a valid signature by any of
+	// the SignatureKeys.
+	ErrUnsigned = errors.New("image is not signed by a trusted key")
+)
+
+// simpleSigning is the payload cosign signs for container images.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// LoadPublicKeys reads all PEM encoded public keys from path.
+func LoadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+
+	keys := []crypto.PublicKey{}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key found in '%s'", path)
+	}
+
+	return keys, nil
+}
+
+func verifyBlob(key crypto.PublicKey, payload, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	}
+
+	return false
+}
+
+// verifyLayer checks a single signature layer of a cosign signature image.
+func verifyLayer(image registryv1.Image, desc registryv1.Descriptor, digest registryv1.Hash) error {
+	encoded, ok := desc.Annotations[cosignSignatureAnnotation]
+	if !ok {
+		return errors.New("layer has no signature")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+
+	layer, err := image.LayerByDigest(desc.Digest)
+	if err != nil {
+		return fmt.Errorf("get layer: %w", err)
+	}
+
+	content, err := layer.Compressed()
+	if err != nil {
+		return fmt.Errorf("get payload: %w", err)
+	}
+	defer content.Close()
+
+	payload, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("read payload: %w", err)
+	}
+
+	trusted := false
+	for _, key := range SignatureKeys {
+		if verifyBlob(key, payload, signature) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return errors.New("signature doesn't match any trusted key")
+	}
+
+	var signed simpleSigning
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+
+	if signed.Critical.Type != cosignSignatureType {
+		return fmt.Errorf("unexpected signature type '%s'", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for '%s'", signed.Critical.Image.DockerManifestDigest)
+	}
+
+	return nil
+}
+
+// verifySignature checks that the image with digest in repo carries a cosign
+// signature by one of the SignatureKeys. Verification is offline, nothing but
+// the registry is contacted.
+func verifySignature(ctx context.Context, repo regname.Repository, digest registryv1.Hash) error {
+	if len(SignatureKeys) == 0 {
+		return nil
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "verifySignature", trace.WithAttributes(attribute.String("digest", digest.String())))
+	defer span.End()
+
+	tag := repo.Tag(strings.Replace(digest.String(), ":", "-", 1) + ".sig")
+	signatures, err := registry.Image(tag, registry.WithContext(ctx))
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			span.SetAttributes(attribute.Bool("signed", false))
+			return fmt.Errorf("%w: no signature found", ErrUnsigned)
+		}
+
+		return fmt.Errorf("get signatures: %w", err)
+	}
+
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return fmt.Errorf("get signature manifest: %w", err)
+	}
+
+	reasons := []string{}
+	for _, desc := range manifest.Layers {
+		err := verifyLayer(signatures, desc, digest)
+		if err == nil {
+			span.SetAttributes(attribute.Bool("signed", true))
+			return nil
+		}
+
+		reasons = append(reasons, err.Error())
+	}
+
+	span.SetAttributes(attribute.Bool("signed", false))
+	return fmt.Errorf("%w: %s", ErrUnsigned, strings.Join(reasons, "; "))
+}
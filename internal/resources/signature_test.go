@@ -0,0 +1,135 @@
+package resources
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+)
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	return key
+}
+
+func TestVerifySignature(t *testing.T) {
+	trusted := generateKey(t)
+	untrusted := generateKey(t)
+
+	testCases := []struct {
+		name     string
+		keys     []crypto.PublicKey
+		signer   crypto.Signer
+		other    bool
+		unsigned bool
+	}{
+		{
+			name:     "disabled",
+			keys:     []crypto.PublicKey{},
+			signer:   nil,
+			unsigned: false,
+		},
+		{
+			name:     "signed",
+			keys:     []crypto.PublicKey{trusted.Public()},
+			signer:   trusted,
+			unsigned: false,
+		},
+		{
+			name:     "multiple-keys",
+			keys:     []crypto.PublicKey{untrusted.Public(), trusted.Public()},
+			signer:   trusted,
+			unsigned: false,
+		},
+		{
+			name:     "unsigned",
+			keys:     []crypto.PublicKey{trusted.Public()},
+			signer:   nil,
+			unsigned: true,
+		},
+		{
+			name:     "untrusted",
+			keys:     []crypto.PublicKey{trusted.Public()},
+			signer:   untrusted,
+			unsigned: true,
+		},
+		{
+			name:     "other-digest",
+			keys:     []crypto.PublicKey{trusted.Public()},
+			signer:   trusted,
+			other:    true,
+			unsigned: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			host := test.UseLocalRegistry(t)
+			repo := fmt.Sprintf("%s/org/image", host)
+			digest := test.PushIndex(t, repo+":latest", []test.PlatformImage{
+				{Platform: registryv1.Platform{OS: "linux", Architecture: "amd64"}},
+			})
+
+			if testCase.signer != nil {
+				signed := digest
+				if testCase.other {
+					signed = registryv1.Hash{Algorithm: "sha256", Hex: "0000000000000000000000000000000000000000000000000000000000000000"}
+				}
+				test.PushSignature(t, repo, digest, signed, testCase.signer)
+			}
+
+			SignatureKeys = testCase.keys
+			defer func() { SignatureKeys = []crypto.PublicKey{} }()
+
+			_, err := containerArchitectures(context.Background(), repo+":latest")
+			if testCase.unsigned {
+				if !errors.Is(err, ErrUnsigned) {
+					t.Errorf("Expected unsigned error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Failed to get container architectures: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	content := []byte{}
+	for i := 0; i < 2; i++ {
+		der, err := x509.MarshalPKIXPublicKey(generateKey(t).Public())
+		if err != nil {
+			t.Fatalf("Failed to marshal key: %v", err)
+		}
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Failed to write keys: %v", err)
+	}
+
+	keys, err := LoadPublicKeys(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d", len(keys))
+	}
+}
@@ -0,0 +1,201 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+
+	"github.com/ongy/k8s-auto-arch/internal/util"
+)
+
+// Bump when the snapshot format changes incompatibly.
+const snapshotVersion = 1
+
+type snapshotImage struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest,omitempty"`
+	// Null for images that don't constrain the architecture, empty for images
+	// that run nowhere.
+	Architectures []string  `json:"architectures"`
+	Source        string    `json:"source,omitempty"`
+	ResolvedAt    time.Time `json:"resolvedAt"`
+	Warnings      []string  `json:"warnings,omitempty"`
+}
+
+type snapshot struct {
+	Version int             `json:"version"`
+	Images  []snapshotImage `json:"images"`
+}
+
+func toSnapshot(image *Image) snapshotImage {
+	ret := snapshotImage{
+		Reference:  image.Reference,
+		Digest:     image.Digest,
+		Source:     image.Source,
+		ResolvedAt: image.ResolvedAt,
+		Warnings:   image.Warnings,
+	}
+	if image.Architectures != nil {
+		ret.Architectures = util.Keys(image.Architectures)
+		slices.Sort(ret.Architectures)
+	}
+
+	return ret
+}
+
+func fromSnapshot(image snapshotImage) *Image {
+	ret := &Image{
+		Reference:  image.Reference,
+		Digest:     image.Digest,
+		Source:     image.Source,
+		ResolvedAt: image.ResolvedAt,
+		Warnings:   image.Warnings,
+	}
+	if image.Architectures != nil {
+		ret.Architectures = map[string]bool{}
+		for _, arch := range image.Architectures {
+			ret.Architectures[arch] = true
+		}
+	}
+
+	return ret
+}
+
+// Export writes the cached images as versioned JSON. If refs are given, only
+// those images are written.
+func (c *Cache) Export(w io.Writer, refs ...string) error {
+	c.mu.Lock()
+	keys := util.Keys(c.entries)
+	if len(refs) > 0 {
+		keys = []string{}
+		for _, ref := range refs {
+			if _, ok := c.entries[cacheKey(ref)]; ok {
+				keys = append(keys, cacheKey(ref))
+			}
+		}
+	}
+	slices.Sort(keys)
+
+	data := snapshot{Version: snapshotVersion, Images: []snapshotImage{}}
+	for _, key := range keys {
+		data.Images = append(data.Images, toSnapshot(c.entries[key].image))
+	}
+	c.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Import adds the images of a snapshot written by Export to the cache, see Put
+// for which entries are replaced. The age of the imported images is preserved,
+// so they expire as if they were never written to disk. Returns the number of
+// images taken from the snapshot.
+func (c *Cache) Import(r io.Reader) (int, error) {
+	data := snapshot{}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return 0, fmt.Errorf("decode snapshot: %w", err)
+	}
+	if data.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d, expected %d", data.Version, snapshotVersion)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	imported := 0
+	for _, image := range data.Images {
+		if c.put(fromSnapshot(image)) {
+			imported++
+		}
+	}
+
+	return imported, nil
+}
+
+// Save writes a snapshot of the cache to path. The file is replaced
+// atomically, so a crash never leaves a truncated snapshot behind.
+func (c *Cache) Save(ctx context.Context, path string) error {
+	_, span := otel.Tracer("").Start(ctx, "Cache.Save", trace.WithAttributes(attribute.String("path", path)))
+	defer span.End()
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err := c.Export(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Load imports the snapshot at path. A missing file is not an error, it's
+// expected on the very first start.
+func (c *Cache) Load(ctx context.Context, path string) (int, error) {
+	_, span := otel.Tracer("").Start(ctx, "Cache.Load", trace.WithAttributes(attribute.String("path", path)))
+	defer span.End()
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	imported, err := c.Import(file)
+	if err != nil {
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int("images", imported))
+
+	return imported, nil
+}
+
+// SaveEvery snapshots the cache to path every interval until ctx is done, and
+// a last time before returning.
+func (c *Cache) SaveEvery(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Save(ctx, path); err != nil {
+				slog.WarnContext(ctx, "Failed to snapshot cache", "path", path, "err", err)
+			}
+		case <-ctx.Done():
+			if err := c.Save(context.Background(), path); err != nil {
+				slog.WarnContext(ctx, "Failed to snapshot cache", "path", path, "err", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	resolvedAt := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	images := []*Image{
+		{Reference: "image:1.0", Digest: "sha256:1234", Architectures: map[string]bool{"amd64": true, "arm64": true}, Source: SourceIndex, ResolvedAt: resolvedAt},
+		{Reference: "scratch", Source: SourceUnconstrained, ResolvedAt: resolvedAt},
+		// All platforms were dropped, the image runs nowhere.
+		{Reference: "dropped:1.0", Architectures: map[string]bool{}, Source: SourceIndex, ResolvedAt: resolvedAt},
+	}
+
+	cache := NewCache(nil)
+	for _, image := range images {
+		cache.entries[cacheKey(image.Reference)] = &cacheEntry{image: image}
+	}
+
+	buffer := bytes.Buffer{}
+	if err := cache.Export(&buffer); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	imported := NewCache(nil)
+	count, err := imported.Import(&buffer)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if count != len(images) {
+		t.Errorf("got != want: %d != %d images", count, len(images))
+	}
+
+	for _, image := range images {
+		entry, ok := imported.entries[cacheKey(image.Reference)]
+		if !ok {
+			t.Fatalf("Missing image after import: %s", image.Reference)
+		}
+		if !reflect.DeepEqual(entry.image, image) {
+			t.Errorf("got != want: %v != %v", entry.image, image)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		existing time.Time
+		count    int
+		err      bool
+	}{
+		{
+			name:  "new",
+			input: `{"version": 1, "images": [{"reference": "image:1.0", "architectures": ["amd64"], "resolvedAt": "2023-09-01T00:00:00Z"}]}`,
+			count: 1,
+		},
+		{
+			name:     "older",
+			input:    `{"version": 1, "images": [{"reference": "image:1.0", "architectures": ["amd64"], "resolvedAt": "2023-09-01T00:00:00Z"}]}`,
+			existing: time.Date(2023, 9, 2, 0, 0, 0, 0, time.UTC),
+			count:    0,
+		},
+		{
+			name:     "newer",
+			input:    `{"version": 1, "images": [{"reference": "image:1.0", "architectures": ["amd64"], "resolvedAt": "2023-09-01T00:00:00Z"}]}`,
+			existing: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC),
+			count:    1,
+		},
+		{
+			name:  "version",
+			input: `{"version": 2, "images": []}`,
+			err:   true,
+		},
+		{
+			name:  "garbage",
+			input: `images`,
+			err:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cache := NewCache(nil)
+			if !testCase.existing.IsZero() {
+				cache.entries[cacheKey("image:1.0")] = &cacheEntry{image: &Image{Reference: "image:1.0", ResolvedAt: testCase.existing}}
+			}
+
+			count, err := cache.Import(strings.NewReader(testCase.input))
+			if testCase.err {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to import: %v", err)
+			}
+
+			if count != testCase.count {
+				t.Errorf("got != want: %d != %d images", count, testCase.count)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	now := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	empty := NewCache(nil)
+	count, err := empty.Load(context.Background(), path)
+	if err != nil || count != 0 {
+		t.Fatalf("Expected missing snapshot to be empty, got: %d, %v", count, err)
+	}
+
+	resolver := &fakeResolver{arch: "amd64"}
+	cache := NewCache(resolver)
+	cache.now = func() time.Time { return now }
+	if _, err := cache.Get(context.Background(), "image:1.0"); err != nil {
+		t.Fatalf("Failed to fill cache: %v", err)
+	}
+	if err := cache.Save(context.Background(), path); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	// The TTL keeps running while the snapshot is on disk.
+	restarted := NewCache(resolver)
+	restarted.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := restarted.Load(context.Background(), path); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	resolver.mu.Lock()
+	resolver.calls = 0
+	resolver.arch = "arm64"
+	resolver.mu.Unlock()
+
+	got, err := restarted.Get(context.Background(), "image:1.0")
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if !got.Architectures["arm64"] || resolver.calls != 1 {
+		t.Errorf("Expected expired image to be resolved again, got: %v after %d calls", got, resolver.calls)
+	}
+}
@@ -0,0 +1,261 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	registry "github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+
+	"github.com/ongy/k8s-auto-arch/internal/util"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+var (
+	// SuggestionCandidates is the number of tags closest to the requested one
+	// that are resolved when looking for suggestions.
+	SuggestionCandidates = 10
+	// SuggestionLimit is the maximum number of suggested tags.
+	SuggestionLimit = 3
+
+	// Tag lists and the platforms of the candidates rarely change, so they
+	// are cached independently of the images used by pods.
+	tagLists  = util.NewTTLCache[[]string](time.Hour)
+	tagImages = util.NewTTLCache[*Image](time.Hour)
+
+	versionPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(.*)$`)
+)
+
+// SetSuggestionTTL sets how long tag lists and the platforms of suggested tags
+// are cached. Previously cached entries are dropped.
+func SetSuggestionTTL(ttl time.Duration) {
+	tagLists = util.NewTTLCache[[]string](ttl)
+	tagImages = util.NewTTLCache[*Image](ttl)
+}
+
+type tagVersion struct {
+	numbers [3]int
+	suffix  string
+}
+
+func parseTagVersion(tag string) (tagVersion, bool) {
+	matches := versionPattern.FindStringSubmatch(tag)
+	if matches == nil {
+		return tagVersion{}, false
+	}
+
+	version := tagVersion{suffix: matches[4]}
+	for i := range version.numbers {
+		if matches[i+1] == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return tagVersion{}, false
+		}
+		version.numbers[i] = number
+	}
+
+	return version, true
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+// tagCandidates returns the tags that look like versions of the same flavor
+// as tag, closest versions first. If tag isn't a version, e.g. "latest", the
+// newest plain versions are returned.
+func tagCandidates(tag string, tags []string) []string {
+	requested, isVersion := parseTagVersion(tag)
+
+	candidates := []string{}
+	versions := map[string]tagVersion{}
+	for _, candidate := range tags {
+		if candidate == tag {
+			continue
+		}
+
+		version, ok := parseTagVersion(candidate)
+		if !ok || version.suffix != requested.suffix {
+			continue
+		}
+
+		candidates = append(candidates, candidate)
+		versions[candidate] = version
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		left, right := versions[candidates[i]], versions[candidates[j]]
+		for k := range left.numbers {
+			if isVersion {
+				leftDistance := abs(left.numbers[k] - requested.numbers[k])
+				rightDistance := abs(right.numbers[k] - requested.numbers[k])
+				if leftDistance != rightDistance {
+					return leftDistance < rightDistance
+				}
+			}
+
+			// Prefer newer versions at the same distance.
+			if left.numbers[k] != right.numbers[k] {
+				return left.numbers[k] > right.numbers[k]
+			}
+		}
+
+		return candidates[i] < candidates[j]
+	})
+
+	if len(candidates) > SuggestionCandidates {
+		candidates = candidates[:SuggestionCandidates]
+	}
+
+	return candidates
+}
+
+// splitReference splits an image reference into the repository as written
+// and its tag. The tag is empty for references by digest.
+func splitReference(refString string) (string, string, error) {
+	ref, err := regname.ParseReference(refString)
+	if err != nil {
+		return "", "", fmt.Errorf("parse image reference: %w", err)
+	}
+
+	repository, _, _ := strings.Cut(refString, "@")
+	tag, ok := ref.(regname.Tag)
+	if !ok {
+		if tag, err = regname.NewTag(repository); err != nil {
+			return repository, "", nil
+		}
+
+		return strings.TrimSuffix(repository, ":"+tag.TagStr()), "", nil
+	}
+
+	// The tag may be implicit.
+	return strings.TrimSuffix(repository, ":"+tag.TagStr()), tag.TagStr(), nil
+}
+
+func listTags(ctx context.Context, repository string) ([]string, error) {
+	if tags, ok := tagLists.Get(repository); ok {
+		return tags, nil
+	}
+
+	repo, err := regname.NewRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("parse repository: %w", err)
+	}
+
+	tags, err := registry.List(repo, registry.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+
+	tagLists.Set(repository, tags)
+	return tags, nil
+}
+
+// SuggestTags returns the tags of the image's repository closest to the
+// requested one that support all missing architectures.
+func SuggestTags(ctx context.Context, resolver Resolver, refString string, missing []string) ([]*Image, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "SuggestTags", trace.WithAttributes(
+		attribute.String("container", refString),
+		attribute.StringSlice("missing", missing),
+	))
+	defer span.End()
+
+	repository, tag, err := splitReference(refString)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := listTags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := tagCandidates(tag, tags)
+	images := make([]*Image, len(candidates))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		candidateRef := fmt.Sprintf("%s:%s", repository, candidate)
+		if image, ok := tagImages.Get(candidateRef); ok {
+			images[i] = image
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, candidateRef string) {
+			defer wg.Done()
+
+			// Warnings about candidates aren't relevant to the request.
+			image, err := resolver.Resolve(warnings.NewContext(ctx), candidateRef, nil)
+			if err != nil {
+				slog.DebugContext(ctx, "Failed to resolve tag candidate", "candidate", candidateRef, "err", err)
+				if ctx.Err() != nil {
+					return
+				}
+				image = nil
+			}
+
+			// Failed candidates are cached as well, they are unlikely to
+			// succeed on the next attempt.
+			tagImages.Set(candidateRef, image)
+			mu.Lock()
+			images[i] = image
+			mu.Unlock()
+		}(i, candidateRef)
+	}
+
+	// Slow candidates are left out rather than delaying the admission.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		span.SetAttributes(attribute.Bool("timeout", true))
+	}
+
+	mu.Lock()
+	resolved := slices.Clone(images)
+	mu.Unlock()
+
+	ret := []*Image{}
+	for _, image := range resolved {
+		// Fallbacks only claim to support the architectures.
+		if image == nil || image.Architectures == nil || image.Source == SourceFallback {
+			continue
+		}
+
+		supported := true
+		for _, arch := range missing {
+			supported = supported && image.Architectures[arch]
+		}
+		if supported {
+			ret = append(ret, image)
+		}
+
+		if len(ret) >= SuggestionLimit {
+			break
+		}
+	}
+
+	return ret, nil
+}
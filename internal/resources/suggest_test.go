@@ -0,0 +1,183 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/exp/slices"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+)
+
+func TestTagCandidates(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tag      string
+		tags     []string
+		expected []string
+	}{
+		{
+			name:     "empty",
+			tag:      "1.0",
+			tags:     []string{},
+			expected: []string{},
+		},
+		{
+			name:     "nearest",
+			tag:      "1.2.3",
+			tags:     []string{"1.2.3", "1.2.4", "1.2.1", "1.3.0", "2.0.0", "0.9.0"},
+			expected: []string{"1.2.4", "1.2.1", "1.3.0", "2.0.0", "0.9.0"},
+		},
+		{
+			name:     "flavor",
+			tag:      "1.2-alpine",
+			tags:     []string{"1.2", "1.3", "1.3-alpine", "1.1-alpine", "latest"},
+			expected: []string{"1.3-alpine", "1.1-alpine"},
+		},
+		{
+			name:     "prefix",
+			tag:      "v1.2",
+			tags:     []string{"v1.3", "1.1"},
+			expected: []string{"v1.3", "1.1"},
+		},
+		{
+			name:     "latest",
+			tag:      "latest",
+			tags:     []string{"1.0", "2.1", "2.0", "2.1-alpine", "edge"},
+			expected: []string{"2.1", "2.0", "1.0"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := tagCandidates(testCase.tag, testCase.tags)
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestSplitReference(t *testing.T) {
+	testCases := []struct {
+		name       string
+		input      string
+		repository string
+		tag        string
+	}{
+		{
+			name:       "tag",
+			input:      "registry.local/org/image:1.2",
+			repository: "registry.local/org/image",
+			tag:        "1.2",
+		},
+		{
+			name:       "implicit",
+			input:      "registry.local:5000/image",
+			repository: "registry.local:5000/image",
+			tag:        "latest",
+		},
+		{
+			name:       "digest",
+			input:      "image:1.2@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			repository: "image",
+			tag:        "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			repository, tag, err := splitReference(testCase.input)
+			if err != nil {
+				t.Fatalf("Failed to split reference: %v", err)
+			}
+
+			if repository != testCase.repository || tag != testCase.tag {
+				t.Errorf("got != want: %s, %s != %s, %s", repository, tag, testCase.repository, testCase.tag)
+			}
+		})
+	}
+}
+
+func TestSuggestTags(t *testing.T) {
+	amd64 := test.PlatformImage{Platform: registryv1.Platform{OS: "linux", Architecture: "amd64"}}
+	arm64 := test.PlatformImage{Platform: registryv1.Platform{OS: "linux", Architecture: "arm64"}}
+
+	host := test.UseLocalRegistry(t)
+	repo := fmt.Sprintf("%s/org/image", host)
+	tags := map[string][]test.PlatformImage{
+		"1.0":        {amd64},
+		"1.1":        {amd64, arm64},
+		"1.2":        {amd64},
+		"1.3":        {amd64, arm64},
+		"2.0":        {amd64, arm64},
+		"3.0":        {amd64, arm64},
+		"1.0-alpine": {amd64, arm64},
+	}
+	for tag, images := range tags {
+		test.PushIndex(t, fmt.Sprintf("%s:%s", repo, tag), images)
+	}
+
+	SetSuggestionTTL(time.Hour)
+	got, err := SuggestTags(context.Background(), Registry{}, repo+":1.0", []string{"arm64"})
+	if err != nil {
+		t.Fatalf("Failed to suggest tags: %v", err)
+	}
+
+	refs := []string{}
+	for _, image := range got {
+		refs = append(refs, image.Reference)
+	}
+
+	want := []string{repo + ":1.1", repo + ":1.3", repo + ":2.0"}
+	if !slices.Equal(refs, want) {
+		t.Errorf("got != want: %v != %v", refs, want)
+	}
+
+	// The results are cached, so they survive the tags moving.
+	test.PushIndex(t, repo+":1.1", []test.PlatformImage{amd64})
+	got, err = SuggestTags(context.Background(), Registry{}, repo+":1.0", []string{"arm64"})
+	if err != nil {
+		t.Fatalf("Failed to suggest tags: %v", err)
+	}
+	if len(got) != len(want) || got[0].Reference != want[0] {
+		t.Errorf("Expected cached suggestions, got: %v", got)
+	}
+}
+
+func TestSuggestTagsTimeout(t *testing.T) {
+	amd64 := test.PlatformImage{Platform: registryv1.Platform{OS: "linux", Architecture: "amd64"}}
+
+	host := test.UseLocalRegistry(t)
+	repo := fmt.Sprintf("%s/org/slow", host)
+	for _, tag := range []string{"1.0", "1.1", "1.2"} {
+		test.PushIndex(t, fmt.Sprintf("%s:%s", repo, tag), []test.PlatformImage{amd64})
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	resolver := ResolverFunc(func(ctx context.Context, ref string, _ *corev1.Pod) (*Image, error) {
+		if ref == repo+":1.1" {
+			return &Image{Reference: ref, Architectures: map[string]bool{"amd64": true, "arm64": true}}, nil
+		}
+		// Ignores ctx, like a registry that doesn't answer.
+		<-release
+		return nil, fmt.Errorf("too slow")
+	})
+
+	SetSuggestionTTL(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	got, err := SuggestTags(ctx, resolver, repo+":1.0", []string{"arm64"})
+	if err != nil {
+		t.Fatalf("Failed to suggest tags: %v", err)
+	}
+
+	if len(got) != 1 || got[0].Reference != repo+":1.1" {
+		t.Errorf("Expected the resolved candidate, got: %v", got)
+	}
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"testing"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+// refNameAnnotation names the entries of an OCI image layout.
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// NewLayout creates an empty OCI image layout for the duration of the test.
+func NewLayout(t *testing.T) layout.Path {
+	path, err := layout.Write(t.TempDir(), empty.Index)
+	if err != nil {
+		t.Fatalf("Failed to create layout: %v", err)
+	}
+
+	return path
+}
+
+// LayoutImage adds image to the layout under the reference name.
+func LayoutImage(t *testing.T, path layout.Path, name string, image registryv1.Image) registryv1.Hash {
+	if err := path.AppendImage(image, layout.WithAnnotations(map[string]string{refNameAnnotation: name})); err != nil {
+		t.Fatalf("Failed to add image to layout: %v", err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get image digest: %v", err)
+	}
+
+	return digest
+}
+
+// LayoutIndex adds an index with the given platforms to the layout under the
+// reference name. Missing images are left out of the layout.
+func LayoutIndex(t *testing.T, path layout.Path, name string, images []PlatformImage) registryv1.Hash {
+	index, missing := platformIndex(t, images)
+	if err := path.AppendIndex(index, layout.WithAnnotations(map[string]string{refNameAnnotation: name})); err != nil {
+		t.Fatalf("Failed to add index to layout: %v", err)
+	}
+
+	for _, digest := range missing {
+		if err := path.RemoveBlob(digest); err != nil {
+			t.Fatalf("Failed to remove manifest: %v", err)
+		}
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+
+	return digest
+}
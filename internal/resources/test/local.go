@@ -0,0 +1,187 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	registry "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+)
+
+// Captured before any test replaces it with a testTripper.
+var defaultTransport = registry.DefaultTransport
+
+// UseLocalRegistry starts an in-process registry for the duration of the test
+// and returns its host. Unlike UseTestRegistry it implements the complete
+// distribution API, so images can be pushed to it.
+func UseLocalRegistry(t *testing.T) string {
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	registry.DefaultTransport = defaultTransport
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse registry url: %v", err)
+	}
+
+	return serverURL.Host
+}
+
+// PlatformImage describes an entry of an index pushed by PushIndex.
+type PlatformImage struct {
+	// Platform is advertised in the index.
+	Platform registryv1.Platform
+	// Config is recorded in the image config, if set. Defaults to Platform.
+	Config *registryv1.Platform
+	// Missing deletes the manifest after the index was pushed.
+	Missing bool
+}
+
+// PlatformConfig returns an image with random content whose config records
+// the given platform.
+func PlatformConfig(t *testing.T, platform registryv1.Platform) registryv1.Image {
+	image, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("Failed to create random image: %v", err)
+	}
+
+	config, err := image.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to get image config: %v", err)
+	}
+
+	config.Architecture = platform.Architecture
+	config.OS = platform.OS
+	config.Variant = platform.Variant
+
+	image, err = mutate.ConfigFile(image, config)
+	if err != nil {
+		t.Fatalf("Failed to set image config: %v", err)
+	}
+
+	return image
+}
+
+// PushImage pushes image to ref on a registry started with UseLocalRegistry.
+func PushImage(t *testing.T, ref string, image registryv1.Image) registryv1.Hash {
+	parsed, err := regname.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+
+	if err := registry.Write(parsed, image); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get image digest: %v", err)
+	}
+
+	return digest
+}
+
+// platformIndex builds an index with the given platforms. It returns the
+// digests of the images that should be missing.
+func platformIndex(t *testing.T, images []PlatformImage) (registryv1.ImageIndex, []registryv1.Hash) {
+	index := registryv1.ImageIndex(empty.Index)
+	missing := []registryv1.Hash{}
+	for _, info := range images {
+		config := info.Platform
+		if info.Config != nil {
+			config = *info.Config
+		}
+		image := PlatformConfig(t, config)
+
+		platform := info.Platform
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: registryv1.Descriptor{Platform: &platform},
+		})
+
+		if info.Missing {
+			digest, err := image.Digest()
+			if err != nil {
+				t.Fatalf("Failed to get image digest: %v", err)
+			}
+			missing = append(missing, digest)
+		}
+	}
+
+	return index, missing
+}
+
+// PushIndex pushes an index with the given platforms to ref on a registry
+// started with UseLocalRegistry.
+func PushIndex(t *testing.T, ref string, images []PlatformImage) registryv1.Hash {
+	parsed, err := regname.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+
+	index, missing := platformIndex(t, images)
+	if err := registry.WriteIndex(parsed, index); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+
+	for _, digest := range missing {
+		if err := registry.Delete(parsed.Context().Digest(digest.String())); err != nil {
+			t.Fatalf("Failed to delete manifest: %v", err)
+		}
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("Failed to get index digest: %v", err)
+	}
+
+	return digest
+}
+
+// PushSignature pushes a cosign signature for digest made with key to repo on
+// a registry started with UseLocalRegistry. The signed payload claims the
+// digest signed, which usually is digest itself.
+func PushSignature(t *testing.T, repo string, digest, signed registryv1.Hash, key crypto.Signer) {
+	parsed, err := regname.NewRepository(repo)
+	if err != nil {
+		t.Fatalf("Failed to parse repository: %v", err)
+	}
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, repo, signed))
+	hash := sha256.Sum256(payload)
+	signature, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign payload: %v", err)
+	}
+
+	image, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{
+			"dev.cosignproject.cosign/signature": base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create signature image: %v", err)
+	}
+
+	tag := parsed.Tag(strings.Replace(digest.String(), ":", "-", 1) + ".sig")
+	if err := registry.Write(tag, image); err != nil {
+		t.Fatalf("Failed to push signature: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	regname "github.com/google/go-containerregistry/pkg/name"
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	registry "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+// VerifyMode selects how thoroughly the platforms listed in an image index are
+// checked before they are trusted.
+type VerifyMode string
+
+const (
+	// VerifyNone trusts the platforms listed in the index.
+	VerifyNone VerifyMode = "none"
+	// VerifyManifest checks that the manifest of every platform exists.
+	VerifyManifest VerifyMode = "manifest"
+	// VerifyConfig additionally checks that the config of every platform
+	// matches the platform advertised in the index.
+	VerifyConfig VerifyMode = "config"
+)
+
+var (
+	// PlatformVerification is applied to every image index that is resolved.
+	PlatformVerification = VerifyNone
+	// DropUnverifiedPlatforms removes platforms that fail verification.
+	// Otherwise they are kept and only reported as warnings.
+	DropUnverifiedPlatforms = true
+)
+
+func ParseVerifyMode(mode string) (VerifyMode, error) {
+	switch VerifyMode(mode) {
+	case VerifyNone, VerifyManifest, VerifyConfig:
+		return VerifyMode(mode), nil
+	}
+
+	return VerifyNone, fmt.Errorf("unknown verify mode '%s'", mode)
+}
+
+func platformString(platform *registryv1.Platform) string {
+	parts := []string{platform.OS, platform.Architecture}
+	if platform.Variant != "" {
+		parts = append(parts, platform.Variant)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// headFunc returns the media type of the manifest with digest, or an error if
+// it doesn't exist.
+type headFunc func(ctx context.Context, digest registryv1.Hash) (types.MediaType, error)
+
+// registryHead asks the registry of repo for manifests.
+func registryHead(repo regname.Repository) headFunc {
+	return func(ctx context.Context, digest registryv1.Hash) (types.MediaType, error) {
+		desc, err := registry.Head(repo.Digest(digest.String()), registry.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+
+		return desc.MediaType, nil
+	}
+}
+
+func verifyPlatform(ctx context.Context, index registryv1.ImageIndex, head headFunc, desc registryv1.Descriptor) error {
+	ctx, span := otel.Tracer("").Start(ctx, "verifyPlatform", trace.WithAttributes(
+		attribute.String("digest", desc.Digest.String()),
+		attribute.String("platform", platformString(desc.Platform)),
+	))
+	defer span.End()
+
+	if PlatformVerification == VerifyNone {
+		return nil
+	}
+
+	mediaType, err := head(ctx, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("head manifest: %w", err)
+	}
+
+	if PlatformVerification != VerifyConfig {
+		return nil
+	}
+
+	if mediaType.IsIndex() {
+		// Nested indexes don't have a config of their own.
+		return nil
+	}
+
+	image, err := index.Image(desc.Digest)
+	if err != nil {
+		return fmt.Errorf("get image: %w", err)
+	}
+
+	config, err := image.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("get imageConfig: %w", err)
+	}
+
+	// Legacy names, e.g. aarch64 in the config for arm64 in the index, are the
+	// same platform.
+	configArch, configVariant := NormalizeArchitecture(config.Architecture, config.Variant)
+	indexArch, indexVariant := NormalizeArchitecture(desc.Platform.Architecture, desc.Platform.Variant)
+	if configArch != indexArch {
+		return fmt.Errorf("config architecture is '%s'", config.Architecture)
+	}
+	// Many images only record the architecture, so only compare what's there.
+	if config.OS != "" && desc.Platform.OS != "" && config.OS != desc.Platform.OS {
+		return fmt.Errorf("config os is '%s'", config.OS)
+	}
+	if configVariant != "" && indexVariant != "" && configVariant != indexVariant {
+		return fmt.Errorf("config variant is '%s'", config.Variant)
+	}
+
+	return nil
+}
+
+// verifiedPlatforms returns the index entries that pass PlatformVerification.
+// Entries failing it are reported as warnings and dropped if
+// DropUnverifiedPlatforms is set.
+func verifiedPlatforms(ctx context.Context, refString string, head headFunc, index registryv1.ImageIndex, manifests []registryv1.Descriptor) []registryv1.Descriptor {
+	if PlatformVerification == VerifyNone {
+		return manifests
+	}
+
+	ctx, span := otel.Tracer("").Start(ctx, "verifiedPlatforms", trace.WithAttributes(attribute.String("mode", string(PlatformVerification))))
+	defer span.End()
+
+	errs := make([]error, len(manifests))
+	var wg sync.WaitGroup
+	for i := range manifests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = verifyPlatform(ctx, index, head, manifests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	ret := []registryv1.Descriptor{}
+	for i, desc := range manifests {
+		if errs[i] == nil {
+			ret = append(ret, desc)
+			continue
+		}
+
+		platform := platformString(desc.Platform)
+		span.AddEvent("platform verification failed", trace.WithAttributes(
+			attribute.String("platform", platform),
+			attribute.String("err", errs[i].Error()),
+		))
+
+		if DropUnverifiedPlatforms {
+			warnings.Add(ctx, "image %s: dropping platform %s: %v", refString, platform, errs[i])
+		} else {
+			warnings.Add(ctx, "image %s: platform %s failed verification: %v", refString, platform, errs[i])
+			ret = append(ret, desc)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,129 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	registryv1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/exp/slices"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources/test"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+	"github.com/ongy/k8s-auto-arch/internal/warnings"
+)
+
+func TestVerifiedPlatforms(t *testing.T) {
+	amd64 := registryv1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := registryv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+	testCases := []struct {
+		name     string
+		mode     VerifyMode
+		drop     bool
+		images   []test.PlatformImage
+		expected []string
+		warnings int
+	}{
+		{
+			name:     "none",
+			mode:     VerifyNone,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Missing: true}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "manifest",
+			mode:     VerifyManifest,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "manifest-missing",
+			mode:     VerifyManifest,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Missing: true}},
+			expected: []string{"amd64"},
+			warnings: 1,
+		},
+		{
+			name:     "manifest-mismatch",
+			mode:     VerifyManifest,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Config: &amd64}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "config",
+			mode:     VerifyConfig,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "config-no-variant",
+			mode:     VerifyConfig,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Config: &registryv1.Platform{Architecture: "arm64"}}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "config-legacy",
+			mode:     VerifyConfig,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Config: &registryv1.Platform{OS: "linux", Architecture: "aarch64"}}},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name:     "config-mismatch",
+			mode:     VerifyConfig,
+			drop:     true,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Config: &amd64}},
+			expected: []string{"amd64"},
+			warnings: 1,
+		},
+		{
+			name:     "config-mismatch-keep",
+			mode:     VerifyConfig,
+			drop:     false,
+			images:   []test.PlatformImage{{Platform: amd64}, {Platform: arm64, Config: &amd64}},
+			expected: []string{"amd64", "arm64"},
+			warnings: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			host := test.UseLocalRegistry(t)
+			image := fmt.Sprintf("%s/org/image:latest", host)
+			digest := test.PushIndex(t, image, testCase.images)
+
+			PlatformVerification = testCase.mode
+			DropUnverifiedPlatforms = testCase.drop
+			defer func() {
+				PlatformVerification = VerifyNone
+				DropUnverifiedPlatforms = true
+			}()
+
+			ctx := warnings.NewContext(context.Background())
+			resolved, err := containerArchitectures(ctx, image)
+			if err != nil {
+				t.Fatalf("Failed to get container architectures: %v", err)
+			}
+			if resolved.Digest != digest.String() {
+				t.Errorf("Unexpected digest: %s != %s", resolved.Digest, digest)
+			}
+
+			got := util.Keys(resolved.Architectures)
+			slices.Sort(got)
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+
+			if got := warnings.FromContext(ctx); len(got) != testCase.warnings {
+				t.Errorf("Unexpected warnings: %v", got)
+			}
+		})
+	}
+}
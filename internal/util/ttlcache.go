@@ -0,0 +1,62 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Expired entries are only swept once the cache grows beyond this size.
+const ttlCacheSweepSize = 1024
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// TTLCache is a concurrency safe map whose entries expire after a fixed time.
+type TTLCache[V any] struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]ttlEntry[V]
+
+	// Indirection for testing
+	now func() time.Time
+}
+
+func NewTTLCache[V any](ttl time.Duration) *TTLCache[V] {
+	return &TTLCache[V]{
+		ttl:     ttl,
+		entries: map[string]ttlEntry[V]{},
+		now:     time.Now,
+	}
+}
+
+func (c *TTLCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expires) {
+		delete(c.entries, key)
+		var empty V
+		return empty, false
+	}
+
+	return entry.value, true
+}
+
+func (c *TTLCache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= ttlCacheSweepSize {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	testCases := []struct {
+		name     string
+		set      bool
+		age      time.Duration
+		expected bool
+	}{
+		{
+			name:     "missing",
+			set:      false,
+			age:      0,
+			expected: false,
+		},
+		{
+			name:     "fresh",
+			set:      true,
+			age:      time.Minute,
+			expected: true,
+		},
+		{
+			name:     "expired",
+			set:      true,
+			age:      time.Hour + time.Second,
+			expected: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			now := time.Now()
+			cache := NewTTLCache[string](time.Hour)
+			cache.now = func() time.Time { return now }
+
+			if testCase.set {
+				cache.Set("key", "value")
+			}
+
+			now = now.Add(testCase.age)
+			got, ok := cache.Get("key")
+			if ok != testCase.expected {
+				t.Fatalf("got != want: %v != %v", ok, testCase.expected)
+			}
+			if ok && got != "value" {
+				t.Errorf("got != want: %s != value", got)
+			}
+		})
+	}
+}
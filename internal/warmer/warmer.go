@@ -0,0 +1,161 @@
+// Package warmer resolves the images that are already used in the cluster, so
+// pods created after a restart of the webhook find them cached.
+package warmer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+	"github.com/ongy/k8s-auto-arch/internal/util"
+)
+
+var (
+	// Concurrency is how many images are resolved at the same time.
+	Concurrency = 4
+	// Resolver resolves the images. It should be the one pods are admitted
+	// with, so overrides and local sources are honored.
+	Resolver resources.Resolver = resources.DefaultCache
+)
+
+func addImages(images map[string]bool, spec *corev1.PodSpec) {
+	for _, container := range spec.InitContainers {
+		images[container.Image] = true
+	}
+	for _, container := range spec.Containers {
+		images[container.Image] = true
+	}
+	for _, container := range spec.EphemeralContainers {
+		images[container.Image] = true
+	}
+}
+
+// ClusterImages returns the images of all pods, deployments, statefulsets and
+// daemonsets in the cluster, sorted.
+func ClusterImages(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	ctx, span := otel.Tracer("").Start(ctx, "ClusterImages")
+	defer span.End()
+
+	images := map[string]bool{}
+
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+	for i := range pods.Items {
+		addImages(images, &pods.Items[i].Spec)
+	}
+
+	deployments, err := client.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list deployments: %w", err)
+	}
+	for i := range deployments.Items {
+		addImages(images, &deployments.Items[i].Spec.Template.Spec)
+	}
+
+	statefulSets, err := client.AppsV1().StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list statefulsets: %w", err)
+	}
+	for i := range statefulSets.Items {
+		addImages(images, &statefulSets.Items[i].Spec.Template.Spec)
+	}
+
+	daemonSets, err := client.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list daemonsets: %w", err)
+	}
+	for i := range daemonSets.Items {
+		addImages(images, &daemonSets.Items[i].Spec.Template.Spec)
+	}
+
+	ret := util.Keys(images)
+	slices.Sort(ret)
+	span.SetAttributes(attribute.Int("images", len(ret)))
+
+	return ret, nil
+}
+
+// ReadImages reads a list of images, one per line. Empty lines and lines
+// starting with # are ignored.
+func ReadImages(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open image list: %w", err)
+	}
+	defer file.Close()
+
+	images := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		images = append(images, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read image list: %w", err)
+	}
+
+	return images, nil
+}
+
+// Warm resolves the images with at most Concurrency at a time. Failures are
+// logged, they are an early hint at unreachable registries. Returns the number
+// of images that failed to resolve.
+func Warm(ctx context.Context, images []string) int {
+	ctx, span := otel.Tracer("").Start(ctx, "Warm")
+	defer span.End()
+
+	concurrency := Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	failed := 0
+
+	work := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for image := range work {
+				if _, err := Resolver.Resolve(ctx, image, nil); err != nil {
+					slog.WarnContext(ctx, "Failed to warm image", "container", image, "err", err)
+
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, image := range images {
+		select {
+		case work <- image:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	span.SetAttributes(attribute.Int("images", len(images)), attribute.Int("failed", failed))
+	return failed
+}
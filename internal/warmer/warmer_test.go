@@ -0,0 +1,124 @@
+package warmer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ongy/k8s-auto-arch/internal/resources"
+)
+
+func TestClusterImages(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Image: "init:1.0"}},
+				Containers:     []corev1.Container{{Image: "app:1.0"}},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "deployment", Namespace: "other"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Image: "app:1.0"}, {Image: "sidecar:2.0"}},
+			}}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "statefulset", Namespace: "default"},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Image: "database:3.0"}},
+			}}},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "daemonset", Namespace: "kube-system"},
+			Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Image: "agent:4.0"}},
+			}}},
+		},
+	)
+
+	got, err := ClusterImages(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Failed to list images: %v", err)
+	}
+
+	want := []string{"agent:4.0", "app:1.0", "database:3.0", "init:1.0", "sidecar:2.0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
+
+func TestReadImages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "images")
+	content := "# Base images\napp:1.0\n\n  sidecar:2.0  \n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write image list: %v", err)
+	}
+
+	got, err := ReadImages(path)
+	if err != nil {
+		t.Fatalf("Failed to read images: %v", err)
+	}
+
+	want := []string{"app:1.0", "sidecar:2.0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got != want: %v != %v", got, want)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	var mu sync.Mutex
+	inFlight := 0
+	maxInFlight := 0
+	resolved := []string{}
+
+	Resolver = resources.ResolverFunc(func(_ context.Context, ref string, _ *corev1.Pod) (*resources.Image, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		resolved = append(resolved, ref)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if ref == "unreachable:1.0" {
+			return nil, errors.New("registry down")
+		}
+		return &resources.Image{Reference: ref}, nil
+	})
+	defer func() { Resolver = resources.DefaultCache }()
+
+	Concurrency = 2
+	defer func() { Concurrency = 4 }()
+
+	images := []string{"a:1.0", "b:1.0", "c:1.0", "d:1.0", "unreachable:1.0"}
+	failed := Warm(context.Background(), images)
+
+	if failed != 1 {
+		t.Errorf("got != want: %d != 1 failed", failed)
+	}
+	if maxInFlight > Concurrency {
+		t.Errorf("Resolved %d images at once, limit is %d", maxInFlight, Concurrency)
+	}
+
+	slices.Sort(resolved)
+	if !slices.Equal(resolved, images) {
+		t.Errorf("got != want: %v != %v", resolved, images)
+	}
+}
@@ -0,0 +1,57 @@
+package warnings
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+type contextKey struct{}
+
+type collector struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+// NewContext returns a context that collects the warnings added with Add.
+// The admission handler uses this to return them as admission warnings.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, &collector{})
+}
+
+// Add records a warning for the request handled with ctx. The warning is
+// always logged, but only returned by FromContext if ctx was created with
+// NewContext.
+func Add(ctx context.Context, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	slog.WarnContext(ctx, msg)
+
+	c, ok := ctx.Value(contextKey{}).(*collector)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The same image is often used by multiple containers.
+	if !slices.Contains(c.messages, msg) {
+		c.messages = append(c.messages, msg)
+	}
+}
+
+// FromContext returns the warnings recorded so far, or nil if there are none.
+func FromContext(ctx context.Context) []string {
+	c, ok := ctx.Value(contextKey{}).(*collector)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.messages)
+}
@@ -0,0 +1,60 @@
+package warnings
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestWarnings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		collect  bool
+		input    []string
+		expected []string
+	}{
+		{
+			name:     "empty",
+			collect:  true,
+			input:    []string{},
+			expected: nil,
+		},
+		{
+			name:     "simple",
+			collect:  true,
+			input:    []string{"one"},
+			expected: []string{"one"},
+		},
+		{
+			name:     "duplicate",
+			collect:  true,
+			input:    []string{"one", "two", "one"},
+			expected: []string{"one", "two"},
+		},
+		{
+			name:     "no-collector",
+			collect:  false,
+			input:    []string{"one"},
+			expected: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			if testCase.collect {
+				ctx = NewContext(ctx)
+			}
+
+			for _, msg := range testCase.input {
+				Add(ctx, "%s", msg)
+			}
+
+			got := FromContext(ctx)
+			if !slices.Equal(got, testCase.expected) {
+				t.Errorf("got != want: %v != %v", got, testCase.expected)
+			}
+		})
+	}
+}